@@ -1,3 +1,3 @@
 module github.com/rdleal/go-priorityq
 
-go 1.19
+go 1.23
@@ -0,0 +1,35 @@
+package kpq
+
+import "time"
+
+// DeadlineQueue represents a keyed priority queue whose priority values are deadlines,
+// ordered from the earliest to the latest. It suits timer queues, where due entries are drained periodically.
+//
+// DeadlineQueue embeds a KeyedPriorityQueue, so all of its methods are available too.
+type DeadlineQueue[K comparable] struct {
+	*KeyedPriorityQueue[K, time.Time]
+}
+
+// NewDeadlineQueue returns a new deadline queue, where the earliest deadline has the highest priority.
+// The given opts are applied in order to configure the underlying priority queue.
+func NewDeadlineQueue[K comparable](opts ...Option[K, time.Time]) *DeadlineQueue[K] {
+	return &DeadlineQueue[K]{
+		KeyedPriorityQueue: NewKeyedPriorityQueue[K](func(x, y time.Time) bool {
+			return x.Before(y)
+		}, opts...),
+	}
+}
+
+// PopExpired pops all the entries whose deadline is not after now, i.e., the ones that are due at now,
+// and returns them from the earliest to the latest deadline, or nil if no entry is due.
+func (dq *DeadlineQueue[K]) PopExpired(now time.Time) []Item[K, time.Time] {
+	return dq.PopUntil(func(_ K, deadline time.Time) bool {
+		return !deadline.After(now)
+	})
+}
+
+// NextDeadline returns the earliest deadline in the deadline queue, e.g., to know when to call PopExpired next.
+// It returns false as its last return value if the deadline queue is empty; otherwise, true.
+func (dq *DeadlineQueue[K]) NextDeadline() (time.Time, bool) {
+	return dq.PeekValue()
+}
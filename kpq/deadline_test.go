@@ -0,0 +1,54 @@
+package kpq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeadlineQueue(t *testing.T) {
+	dq := NewDeadlineQueue[string]()
+
+	now := time.Date(2023, time.January, 1, 12, 0, 0, 0, time.UTC)
+	deadlines := map[string]time.Duration{
+		"late":    time.Hour,
+		"due":     0,
+		"overdue": -time.Minute,
+		"soon":    time.Second,
+	}
+	for k, d := range deadlines {
+		if err := dq.Push(k, now.Add(d)); err != nil {
+			t.Fatalf("dq.Push(%q, now%+v): got unexpected error %v", k, d, err)
+		}
+	}
+
+	if got, ok := dq.NextDeadline(); !ok || !got.Equal(now.Add(-time.Minute)) {
+		t.Errorf("dq.NextDeadline(): got (%v, %t); want (%v, %t)", got, ok, now.Add(-time.Minute), true)
+	}
+
+	got := dq.PopExpired(now)
+	want := []string{"overdue", "due"}
+	if len(got) != len(want) {
+		t.Fatalf("dq.PopExpired(now): got %v; want keys %v", got, want)
+	}
+	for i, k := range want {
+		if got[i].Key != k || !got[i].Value.Equal(now.Add(deadlines[k])) {
+			t.Errorf("dq.PopExpired(now)[%d]: got %v; want key %q", i, got[i], k)
+		}
+	}
+
+	if got := dq.PopExpired(now); got != nil {
+		t.Errorf("dq.PopExpired(now): got %v; want nil", got)
+	}
+
+	if got, want := dq.Len(), 2; got != want {
+		t.Errorf("dq.Len(): got %d; want %d", got, want)
+	}
+
+	if got := dq.PopExpired(now.Add(time.Hour)); len(got) != 2 {
+		t.Errorf("dq.PopExpired(now+1h): got %v; want 2 entries", got)
+	}
+
+	if _, ok := dq.NextDeadline(); ok {
+		t.Error("dq.NextDeadline(): got unexpected non empty deadline queue")
+	}
+}
@@ -0,0 +1,114 @@
+package kpq
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Encode writes the entries of the priority queue to w as a stream of records:
+// the number of entries, followed by one length-prefixed record per entry,
+// holding its key and priority value encoded with encoding/gob.
+// Entries are written one at a time, so encoding doesn't hold the whole priority queue in memory.
+// The priority queue is read-locked while it's being encoded.
+func (pq *KeyedPriorityQueue[K, V]) Encode(w io.Writer) error {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	var hdr [binary.MaxVarintLen64]byte
+	if _, err := bw.Write(hdr[:binary.PutUvarint(hdr[:], uint64(pq.Len()))]); err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	for i, k := range pq.pm {
+		if pq.isDead(k) {
+			continue
+		}
+		buf.Reset()
+		if err := enc.Encode(Item[K, V]{Key: k, Value: pq.pv[i]}); err != nil {
+			return fmt.Errorf("keyed priority queue: encoding key \"%v\": %w", k, err)
+		}
+		if _, err := bw.Write(hdr[:binary.PutUvarint(hdr[:], uint64(buf.Len()))]); err != nil {
+			return err
+		}
+		if _, err := buf.WriteTo(bw); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Decode replaces the entries of the priority queue with the ones read from r,
+// which must have been written by Encode. The heap is rebuilt in O(n) time,
+// ordered by the comparison function of the priority queue.
+//
+// If r holds truncated or corrupt input, or duplicate keys, Decode returns an error
+// and the priority queue is left unchanged.
+func (pq *KeyedPriorityQueue[K, V]) Decode(r io.Reader) error {
+	br := bufio.NewReader(r)
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return decodeError(err)
+	}
+
+	// n comes from the input, so it isn't trusted for preallocating.
+	c := n
+	if c > 1024 {
+		c = 1024
+	}
+	items := make([]Item[K, V], 0, c)
+	seen := make(map[K]struct{}, cap(items))
+
+	var buf bytes.Buffer
+	dec := gob.NewDecoder(&buf)
+	for i := uint64(0); i < n; i++ {
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			return decodeError(err)
+		}
+		if size > 1<<62 {
+			return decodeError(errors.New("record too large"))
+		}
+		buf.Reset()
+		if _, err := io.CopyN(&buf, br, int64(size)); err != nil {
+			return decodeError(err)
+		}
+
+		var item Item[K, V]
+		if err := dec.Decode(&item); err != nil {
+			return decodeError(err)
+		}
+		if buf.Len() > 0 {
+			return decodeError(errors.New("trailing data in record"))
+		}
+		if _, ok := seen[item.Key]; ok {
+			return newKeyAlreadyExistsError(item.Key)
+		}
+		seen[item.Key] = struct{}{}
+		items = append(items, item)
+	}
+
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	if pq.reject && len(items) > pq.maxSize {
+		return newCapacityExceededError(items[pq.maxSize].Key, pq.maxSize)
+	}
+	pq.reset(len(items))
+	pq.load(items)
+	return nil
+}
+
+func decodeError(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("keyed priority queue: truncated or corrupt input: %w", io.ErrUnexpectedEOF)
+	}
+	return fmt.Errorf("keyed priority queue: corrupt input: %w", err)
+}
@@ -0,0 +1,158 @@
+package kpq
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestKeyedPriorityQueue_EncodeDecode(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := pq.Encode(&buf); err != nil {
+		t.Fatalf("pq.Encode(): got unexpected error %v", err)
+	}
+
+	decoded := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+	decoded.Push("stale", 1)
+
+	if err := decoded.Decode(&buf); err != nil {
+		t.Fatalf("decoded.Decode(): got unexpected error %v", err)
+	}
+
+	if got, want := decoded.Len(), len(items); got != want {
+		t.Errorf("decoded.Len(): got %d; want %d", got, want)
+	}
+
+	if decoded.Contains("stale") {
+		t.Errorf("decoded.Contains(%q): got true; want false", "stale")
+	}
+
+	want := []string{"first", "second", "third", "fourth", "last"}
+	for _, wantKey := range want {
+		gotKey, _, _ := decoded.Pop()
+		if gotKey != wantKey {
+			t.Errorf("decoded.Pop(): got key %q; want %q", gotKey, wantKey)
+		}
+	}
+}
+
+func TestKeyedPriorityQueue_Decode_InvalidInput(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+	pq.Push("first", 1)
+	pq.Push("second", 2)
+
+	var buf bytes.Buffer
+	if err := pq.Encode(&buf); err != nil {
+		t.Fatalf("pq.Encode(): got unexpected error %v", err)
+	}
+	encoded := buf.Bytes()
+
+	single := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+	single.Push("first", 1)
+	buf = bytes.Buffer{}
+	if err := single.Encode(&buf); err != nil {
+		t.Fatalf("single.Encode(): got unexpected error %v", err)
+	}
+	// the record is one byte longer than its gob payload, which has a single byte length prefix.
+	trailing := append([]byte{1, buf.Bytes()[1] + 1}, buf.Bytes()[2:]...)
+	trailing = append(trailing, 0)
+
+	testCases := []struct {
+		name          string
+		input         []byte
+		wantTruncated bool
+		wantMsg       string
+	}{
+		{name: "Empty", input: nil, wantTruncated: true},
+		{name: "Truncated", input: encoded[:len(encoded)-1], wantTruncated: true},
+		{name: "MissingRecord", input: append([]byte{3}, encoded[1:]...), wantTruncated: true},
+		{name: "Corrupt", input: []byte{1, 4, 0xff, 0xff, 0xff, 0xff}},
+		{name: "TrailingData", input: trailing, wantMsg: "trailing data in record"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			decoded := NewKeyedPriorityQueue[string](func(x, y int) bool {
+				return x < y
+			})
+			decoded.Push("kept", 1)
+
+			err := decoded.Decode(bytes.NewReader(tc.input))
+			if err == nil {
+				t.Fatal("decoded.Decode(): got nil error; want error")
+			}
+
+			if tc.wantTruncated && !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("decoded.Decode(): got error %v; want %v", err, io.ErrUnexpectedEOF)
+			}
+			if tc.wantMsg != "" && errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("decoded.Decode(): got error %v; want it not to report truncated input", err)
+			}
+			if !strings.Contains(err.Error(), tc.wantMsg) {
+				t.Errorf("decoded.Decode(): got error %q; want it to contain %q", err, tc.wantMsg)
+			}
+
+			if !decoded.Contains("kept") || decoded.Len() != 1 {
+				t.Errorf("decoded.Decode(): got queue modified on error; want it unchanged")
+			}
+		})
+	}
+}
+
+func TestKeyedPriorityQueue_Decode_CapacityExceeded(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+	pq.Push("first", 1)
+	pq.Push("second", 2)
+	pq.Push("third", 3)
+
+	var buf bytes.Buffer
+	if err := pq.Encode(&buf); err != nil {
+		t.Fatalf("pq.Encode(): got unexpected error %v", err)
+	}
+
+	decoded := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithMaxSize[string, int](2, false))
+	decoded.Push("kept", 1)
+
+	err := decoded.Decode(&buf)
+	var wantErr CapacityExceededError[string]
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("decoded.Decode(): got error type %T; want it to be %T", err, wantErr)
+	}
+	if !decoded.Contains("kept") || decoded.Len() != 1 {
+		t.Errorf("decoded.Decode(): got queue modified on error; want it unchanged")
+	}
+}
@@ -29,6 +29,25 @@ func ExampleKeyAlreadyExistsError() {
 	// true
 }
 
+func ExampleCapacityExceededError() {
+	pq := kpq.NewKeyedPriorityQueue[string](func(a, b int) bool {
+		return a < b
+	}, kpq.WithMaxSize[string, int](1, false))
+
+	pq.Push("key1", 10)
+	err := pq.Push("key2", 5) // pushing onto a full priority queue should return an error
+	if err != nil {
+		var capErr kpq.CapacityExceededError[string]
+		if errors.As(err, &capErr) {
+			fmt.Println(capErr.Key())
+			fmt.Println(capErr)
+		}
+	}
+	// Output:
+	// key2
+	// keyed priority queue: cannot push key "key2": max size of 1 exceeded
+}
+
 func Example() {
 	// Create a new KeyedPriorityQueue with a custom comparison function
 	cmp := func(a, b int) bool {
@@ -155,3 +174,15 @@ func ExampleKeyedPriorityQueue_Set() {
 	//   6               25
 	//   7                8
 }
+
+func ExampleHeapSort() {
+	s := []int{30, 10, 50, 20, 40}
+
+	// sorts the values in the order they would be popped from a min priority queue.
+	kpq.HeapSort(s, func(a, b int) bool {
+		return a < b
+	})
+	fmt.Println(s)
+	// Output:
+	// [10 20 30 40 50]
+}
@@ -2,14 +2,19 @@
 //
 // A keyed priority queue is a data structure that allows you to associate keys with priority values
 // and efficiently retrieve, update, and remove elements based on their priorities.
-// This package offers concurrent-safe operations that leverages a binary heap to maintain the priority queue.
+// This package offers concurrent-safe operations that leverages a d-ary heap, binary by default, to maintain the priority queue.
 // Operations like Push, Pop, Update and Remove have O(log n) time complexity, where n is the size of the priority queue.
 // The use of a map ensures fast lookups by key. Operations like Peek, Contains and ValueOf have O(1) time complexity.
 package kpq
 
 import (
+	"cmp"
+	"context"
 	"fmt"
+	"math"
 	"sync"
+	"sync/atomic"
+	"unsafe"
 )
 
 type keyError[K comparable] struct {
@@ -57,251 +62,2456 @@ func newKeyNotFoundError[K comparable](k K) error {
 	}
 }
 
+// CapacityExceededError represents an error from calling a Push method
+// with a new key on a full priority queue bounded by WithMaxSize without eviction.
+// Its Key method returns the rejected key.
+type CapacityExceededError[K comparable] struct {
+	keyError[K]
+}
+
+func newCapacityExceededError[K comparable](k K, n int) error {
+	return CapacityExceededError[K]{
+		keyError[K]{
+			key: k,
+			msg: fmt.Sprintf("keyed priority queue: cannot push key \"%v\": max size of %d exceeded", k, n),
+		},
+	}
+}
+
+// MissingValueError represents an error from calling ReplaceValues
+// without a new priority value for a key of the priority queue.
+type MissingValueError[K comparable] struct {
+	keyError[K]
+}
+
+func newMissingValueError[K comparable](k K) error {
+	return MissingValueError[K]{
+		keyError[K]{
+			key: k,
+			msg: fmt.Sprintf("keyed priority queue: no value given for key \"%v\"", k),
+		},
+	}
+}
+
+// Item represents an entry of a keyed priority queue,
+// where Key is the key of the entry and Value is its priority value.
+type Item[K comparable, V any] struct {
+	Key   K
+	Value V
+}
+
 // CmpFunc is a generic function type used for ordering the priority queue.
 type CmpFunc[V any] func(x, y V) bool
 
 // KeyedPriorityQueue represents a generic keyed priority queue,
 // where K is the key type and V is the priority value type.
 //
+// KeyedPriorityQueue is safe for concurrent use, unless it's created with the WithoutLocking option.
+//
+// The heap index arithmetic is guarded against int overflow, so the size of a KeyedPriorityQueue
+// is only bounded by the maximum length of a slice, i.e., math.MaxInt entries,
+// which is math.MaxInt32 on 32-bit platforms.
+//
+// The methods that visit the entries without sorting them, like KeysSeq, ValuesSeq, SnapshotSeq, AppendKeys
+// and the ForEach method of the views, follow the heap order, i.e., the order of the entries in the heap,
+// rather than the randomized iteration order of Go maps. Hence their order is deterministic:
+// the same sequence of operations always yields the same order, which keeps golden-file tests reproducible.
+//
 // KeyedPriorityQueue must not be copied after first use.
 type KeyedPriorityQueue[K comparable, V any] struct {
-	mu sync.RWMutex
+	mu   rwLocker
+	size atomic.Int64 // number of entries; it allows reading the size without locking
+
+	pm  []K       // position map
+	pv  []V       // generic priority values, aligned with pm; pv[i] is the priority value of key pm[i]
+	im  map[K]int // inverse map of pm; note that for a given key k, pm[im[k]] == k
+	cmp CmpFunc[V]
+	d   int // arity of the heap
 
-	pm   []K       // position map
-	im   map[K]int // inverse map of pm; note that for a given key k, pm[im[k]] == k
-	vals map[K]V   // generic priority values of key k
-	cmp  CmpFunc[V]
+	compare3 func(x, y V) int // three-way version of cmp, if the priority queue was created with one
+	seq      map[K]uint64     // insertion sequence of key k, used for breaking ties; nil if ordering is not stable
+	keys     sortKeyCache[V]  // cached sort keys of the entries, aligned with pv, compared instead of calling cmp; nil if not cached
+	nextSeq  uint64           // sequence of the next inserted key
+	refresh  bool             // whether updating a key gives it the next sequence, as if it was newly inserted
+	copier   func(v V) V      // copies the priority values returned by the lookup methods; nil if they're returned as is
+
+	upsert   bool    // whether Push updates existing keys instead of failing
+	trusted  bool    // whether Push skips checking if the key already exists
+	monotone bool    // whether updates never raise the priority of a key, so they only sink it
+	validate bool    // whether bulk constructors check the comparison function
+	maxSize  int     // maximum number of entries; 0 means unbounded
+	reject   bool    // whether pushing onto a full priority queue fails instead of evicting
+	shrinkAt float64 // size to capacity ratio below which pm is shrunk; 0 disables auto shrinking
+	headroom float64 // capacity to size ratio pm is shrunk to
+	growth   float64 // factor by which pm grows when it's full; 0 means the append growth
+
+	dead      map[K]struct{} // keys removed lazily, still present in pm; nil if lazy deletion is disabled
+	deadRatio float64        // tombstones to size ratio above which the heap is compacted
+
+	onEvict func(k K, v V, reason EvictReason) // nil if no eviction callback is set
+	metrics Metrics                            // nil if no metrics are collected
+	evicted []eviction[K, V]                   // evictions pending to be reported once the lock is released
+	changed chan struct{}                      // closed once the lock is released after a write; nil if nobody waits
 }
 
 // NewKeyedPriorityQueue returns a new keyed priority queue
 // that uses the given cmp function for ordering the priority queue.
+// The given opts are applied in order to configure the priority queue.
 //
 // NewKeyedPriorityQueue will panic if cmp is nil.
-func NewKeyedPriorityQueue[K comparable, V any](cmp CmpFunc[V]) *KeyedPriorityQueue[K, V] {
+func NewKeyedPriorityQueue[K comparable, V any](cmp CmpFunc[V], opts ...Option[K, V]) *KeyedPriorityQueue[K, V] {
 	if cmp == nil {
 		panic("keyed priority queue: comparison function cannot be nil")
 	}
-	return &KeyedPriorityQueue[K, V]{
-		pm:   make([]K, 0),
-		im:   make(map[K]int),
-		vals: make(map[K]V),
-		cmp:  cmp,
+	pq := &KeyedPriorityQueue[K, V]{
+		mu:  new(sync.RWMutex),
+		pm:  make([]K, 0),
+		pv:  make([]V, 0),
+		im:  make(map[K]int),
+		cmp: cmp,
+		d:   2,
+	}
+	for _, opt := range opts {
+		opt(pq)
+	}
+	return pq
+}
+
+// NewKeyedPriorityQueueOrdered is like NewKeyedPriorityQueue for ordered priority values, such as numbers and strings,
+// but a nil less function makes the priority queue use their natural ordering instead of panicking,
+// i.e., a min priority queue where the lowest value has the highest priority, as with cmp.Less,
+// which also makes NaNs have the highest priority.
+func NewKeyedPriorityQueueOrdered[K comparable, V cmp.Ordered](less CmpFunc[V], opts ...Option[K, V]) *KeyedPriorityQueue[K, V] {
+	if less == nil {
+		less = cmp.Less[V]
+	}
+	return NewKeyedPriorityQueue(less, opts...)
+}
+
+// NewWithCompare returns a new keyed priority queue ordered by the given three-way compare function,
+// such as cmp.Compare, where a negative result means that x has a higher priority than y.
+// The given opts are applied in order to configure the priority queue.
+//
+// NewWithCompare will panic if compare is nil.
+func NewWithCompare[K comparable, V any](compare func(x, y V) int, opts ...Option[K, V]) *KeyedPriorityQueue[K, V] {
+	if compare == nil {
+		panic("keyed priority queue: comparison function cannot be nil")
+	}
+	pq := NewKeyedPriorityQueue(func(x, y V) bool {
+		return compare(x, y) < 0
+	}, opts...)
+	pq.compare3 = compare
+	return pq
+}
+
+// NewFromItems returns a new keyed priority queue that uses the given cmp function for ordering the priority queue,
+// holding the given items. The given opts are applied in order to configure the priority queue.
+// Unless the priority queue is bounded by WithMaxSize, the heap is built at once in O(n) time,
+// which is faster than pushing the items one by one.
+//
+// If a key appears more than once in items, it returns a KeyAlreadyExistsError error.
+// If the priority queue is bounded by WithMaxSize without eviction and the items don't fit in it,
+// it returns a CapacityExceededError error.
+//
+// NewFromItems will panic if cmp is nil, or if the WithComparatorValidation option is given
+// and cmp is found not to be a strict ordering.
+func NewFromItems[K comparable, V any](cmp CmpFunc[V], items []Item[K, V], opts ...Option[K, V]) (*KeyedPriorityQueue[K, V], error) {
+	pq := NewKeyedPriorityQueue(cmp, opts...)
+	if pq.validate {
+		validateCmp(cmp, items)
+	}
+
+	seen := make(map[K]struct{}, len(items))
+	for _, item := range items {
+		if _, ok := seen[item.Key]; ok {
+			return nil, newKeyAlreadyExistsError(item.Key)
+		}
+		seen[item.Key] = struct{}{}
+	}
+	if pq.reject && len(items) > pq.maxSize {
+		return nil, newCapacityExceededError(items[pq.maxSize].Key, pq.maxSize)
+	}
+
+	pq.reset(max(len(items), cap(pq.pm)))
+	pq.load(items)
+	return pq, nil
+}
+
+// validateCmp spot-checks that cmp is a strict ordering of the values of the given items,
+// i.e., that no value compares before itself, and that no two consecutive values compare before each other.
+// It panics on the first violation found.
+func validateCmp[K comparable, V any](cmp CmpFunc[V], items []Item[K, V]) {
+	for i, item := range items {
+		if cmp(item.Value, item.Value) {
+			panic(fmt.Sprintf("keyed priority queue: invalid comparison function: cmp(x, x) is true for x = %v; "+
+				"it must be a strict ordering, e.g., < rather than <=", item.Value))
+		}
+		if i == 0 {
+			continue
+		}
+		prev := items[i-1].Value
+		if cmp(prev, item.Value) && cmp(item.Value, prev) {
+			panic(fmt.Sprintf("keyed priority queue: invalid comparison function: both cmp(x, y) and cmp(y, x) are true "+
+				"for x = %v and y = %v; it must be a strict ordering", prev, item.Value))
+		}
 	}
 }
 
 // Push inserts the given priority value v onto the priority queue associated with the given key k.
-// If the key already exists in the priority queue, it returns a KeyAlreadyExistsError error.
+// If the key already exists in the priority queue, it returns a KeyAlreadyExistsError error,
+// unless the priority queue was created with the WithUpsertPush option, in which case it behaves like Set.
+// If the priority queue is full and was created with the WithMaxSize option without eviction,
+// it returns a CapacityExceededError error.
 func (pq *KeyedPriorityQueue[K, V]) Push(k K, v V) error {
 	pq.mu.Lock()
-	defer pq.mu.Unlock()
+	defer pq.unlock()
+
+	return pq.insert(k, v)
+}
+
+// PushReport is like Push, but it also reports whether the push changed the highest priority entry,
+// which saves calling Peek after every mutation to detect changes of the head of the priority queue.
+// The highest priority entry changes if its key differs after the push,
+// or if the push succeeded and k is the highest priority key afterwards, since its priority value was set.
+func (pq *KeyedPriorityQueue[K, V]) PushReport(k K, v V) (topChanged bool, err error) {
+	pq.mu.Lock()
+	defer pq.unlock()
 
-	if _, ok := pq.im[k]; ok {
+	return pq.watchTop(k, func() error {
+		return pq.insert(k, v)
+	})
+}
+
+// PushTracked is like Push, but it also reports whether the push grew the backing arrays of the priority queue,
+// i.e., whether its capacity increased, which requires reallocating and copying all of its entries.
+// It's a diagnostic aid, e.g., for correlating latency spikes with reallocations.
+func (pq *KeyedPriorityQueue[K, V]) PushTracked(k K, v V) (grew bool, err error) {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	c := cap(pq.pm)
+	err = pq.insert(k, v)
+	return cap(pq.pm) > c, err
+}
+
+// insert implements Push.
+func (pq *KeyedPriorityQueue[K, V]) insert(k K, v V) error {
+	if pq.trusted {
+		return pq.push(k, v)
+	}
+	if i, ok := pq.index(k); ok {
+		if pq.upsert {
+			pq.update(v, i)
+			return nil
+		}
 		return newKeyAlreadyExistsError(k)
 	}
 
-	pq.push(k, v)
+	return pq.push(k, v)
+}
+
+// Offer is like Push, but it reports whether the given key k and value v were inserted instead of returning an error.
+// It returns false, leaving the existing entry untouched, if the key already exists in the priority queue.
+// It also returns false if the priority queue is full and was created with the WithMaxSize option, either without eviction,
+// or with eviction but v doesn't have a higher priority than the lowest priority entry, so the entry is discarded.
+// Otherwise, it returns true.
+// Unlike Set, it never updates an existing entry, which makes it an insert-if-absent operation,
+// even if the priority queue was created with the WithUpsertPush option.
+func (pq *KeyedPriorityQueue[K, V]) Offer(k K, v V) bool {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	if _, ok := pq.index(k); ok {
+		return false
+	}
+	if err := pq.push(k, v); err != nil {
+		return false
+	}
+	// a full priority queue with eviction discards the entry instead of failing.
+	_, ok := pq.index(k)
+	return ok
+}
+
+// push inserts the given key k and value v, which must not be in the priority queue.
+// It returns a CapacityExceededError error if the priority queue is full and mustn't evict any entry.
+func (pq *KeyedPriorityQueue[K, V]) push(k K, v V) error {
+	if pq.maxSize > 0 && pq.reject && pq.Len() >= pq.maxSize {
+		return newCapacityExceededError(k, pq.maxSize)
+	}
+	if len(pq.dead) > 0 {
+		if i, ok := pq.im[k]; ok {
+			pq.removeAt(i) // k was removed lazily; drop its tombstone first
+			pq.settle()
+		}
+	}
+	if pq.maxSize > 0 && pq.Len() >= pq.maxSize {
+		pq.purge()
+		w := pq.worst()
+		if !pq.cmp(v, pq.pv[w]) {
+			// v doesn't have a higher priority than any entry in the full priority queue.
+			pq.evict(k, v, EvictCapacity)
+			return nil
+		}
+		wk, wv := pq.removeAt(w)
+		pq.evict(wk, wv, EvictCapacity)
+	}
+
+	pq.add(k, v)
+	pq.swim(len(pq.pm) - 1)
+	if pq.metrics != nil {
+		pq.metrics.IncPush()
+	}
 	return nil
 }
 
-func (pq *KeyedPriorityQueue[K, V]) push(k K, v V) {
-	n := len(pq.pm)
+// add appends an entry with the given key k and value v to the heap, without restoring the heap ordering.
+func (pq *KeyedPriorityQueue[K, V]) add(k K, v V) {
+	if pq.growth > 0 && len(pq.pm) == cap(pq.pm) {
+		pq.grow()
+	}
+	pq.im[k] = len(pq.pm)
 	pq.pm = append(pq.pm, k)
-	pq.im[k] = n
-	pq.vals[k] = v
-	pq.swim(n)
+	pq.pv = append(pq.pv, v)
+	if pq.keys != nil {
+		pq.keys.set(len(pq.pv)-1, v)
+	}
+	if pq.seq != nil {
+		pq.seq[k] = pq.nextSeq
+		pq.nextSeq++
+	}
+	pq.size.Add(1)
 }
 
 // Pop removes and returns the highest priority key and value from the priority queue.
 // It returns false as its last return value if the priority queue is empty; otherwise, true.
 func (pq *KeyedPriorityQueue[K, V]) Pop() (K, V, bool) {
 	pq.mu.Lock()
-	defer pq.mu.Unlock()
+	defer pq.unlock()
 
 	if len(pq.pm) == 0 {
 		var k K
 		var v V
 		return k, v, false
 	}
-	n := len(pq.pm) - 1
-	k := pq.pm[0]
-	v := pq.vals[k]
-	pq.swap(0, n)
-	pq.sink(0, n)
-	pq.pm = pq.pm[:n]
-	delete(pq.im, k)
-	delete(pq.vals, k)
+	k, v := pq.removeAt(0)
+	pq.evict(k, v, EvictPop)
+	pq.settle()
+	pq.autoPurge()
+	pq.shrink()
 	return k, v, true
 }
 
-// Set inserts a new entry in the priority queue with the given key and value,
-// if the key is not present in it; otherwise, it updates the priority value associated with the given key.
-func (pq *KeyedPriorityQueue[K, V]) Set(k K, v V) {
+// PopIfTop pops the highest priority entry of the priority queue only if its key is k, returning its value.
+// Otherwise, it leaves the priority queue unchanged and returns false as its last return value.
+// It avoids popping a different entry than intended when the top may have changed concurrently.
+func (pq *KeyedPriorityQueue[K, V]) PopIfTop(k K) (V, bool) {
 	pq.mu.Lock()
-	defer pq.mu.Unlock()
+	defer pq.unlock()
 
-	if i, ok := pq.im[k]; ok {
-		pq.update(k, v, i)
-		return
+	if len(pq.pm) == 0 || pq.pm[0] != k {
+		var v V
+		return v, false
 	}
-
-	pq.push(k, v)
+	_, v := pq.removeAt(0)
+	pq.evict(k, v, EvictPop)
+	pq.settle()
+	pq.autoPurge()
+	pq.shrink()
+	return v, true
 }
 
-// Update changes the priority value associated with the given key k to the given value v.
-// If there's no key k in the priority queue, it returns a KeyNotFoundError error.
-func (pq *KeyedPriorityQueue[K, V]) Update(k K, v V) error {
+// ConsumeTop calls fn with the highest priority key and value of the priority queue, and pops them
+// only if fn returns true, in a single operation. It returns true if an entry was popped; otherwise, false,
+// including when the priority queue is empty, in which case fn isn't called.
+// Unlike calling Peek and then Pop, no other goroutine can pop or change the highest priority entry in between.
+//
+// fn is called while holding the priority queue lock, so it must not call any of its methods.
+func (pq *KeyedPriorityQueue[K, V]) ConsumeTop(fn func(k K, v V) bool) bool {
 	pq.mu.Lock()
-	defer pq.mu.Unlock()
+	defer pq.unlock()
 
-	i, ok := pq.im[k]
-	if !ok {
-		return newKeyNotFoundError(k)
+	if len(pq.pm) == 0 || !fn(pq.pm[0], pq.pv[0]) {
+		return false
 	}
-
-	pq.update(k, v, i)
-	return nil
-}
-
-func (pq *KeyedPriorityQueue[K, V]) update(k K, v V, i int) {
-	pq.vals[k] = v
-	pq.swim(i)
-	pq.sink(i, len(pq.vals))
+	k, v := pq.removeAt(0)
+	pq.evict(k, v, EvictPop)
+	pq.settle()
+	pq.autoPurge()
+	pq.shrink()
+	return true
 }
 
-// Peek returns the highest priority key and value from the priority queue.
-// It returns false as its last return value if the priority queue is empty; otherwise, true.
-func (pq *KeyedPriorityQueue[K, V]) Peek() (K, V, bool) {
-	pq.mu.RLock()
-	defer pq.mu.RUnlock()
+// Reschedule pops the highest priority key of the priority queue and pushes it back with the given value v,
+// in a single operation, returning the key. It returns false as its last return value if the priority queue is empty;
+// otherwise, true.
+// The rescheduled key counts as newly inserted, so with the WithStableOrdering option it's placed behind
+// the keys with an equal priority value, which makes it suitable for round-robin scheduling.
+// Since the key stays in the priority queue, it isn't reported to the eviction callback.
+func (pq *KeyedPriorityQueue[K, V]) Reschedule(v V) (K, bool) {
+	pq.mu.Lock()
+	defer pq.unlock()
 
 	if len(pq.pm) == 0 {
 		var k K
-		var v V
-		return k, v, false
+		return k, false
 	}
-	return pq.pm[0], pq.vals[pq.pm[0]], true
+	return pq.requeueTop(v), true
 }
 
-// PeekKey returns the highest priority key from the priority queue.
-// It returns false as its last return value if the priority queue is empty; otherwise, true.
-func (pq *KeyedPriorityQueue[K, V]) PeekKey() (K, bool) {
-	pq.mu.RLock()
-	defer pq.mu.RUnlock()
+// DeferTop is like Reschedule, but the new priority value of the highest priority key is computed by calling fn
+// with the key and its current priority value, e.g., to back off a job exponentially, in a single operation.
+// It returns the deferred key, and false as its last return value if the priority queue is empty; otherwise, true.
+//
+// fn is called while holding the priority queue lock, so it must not call any of its methods.
+func (pq *KeyedPriorityQueue[K, V]) DeferTop(fn func(k K, v V) V) (K, bool) {
+	pq.mu.Lock()
+	defer pq.unlock()
 
 	if len(pq.pm) == 0 {
 		var k K
 		return k, false
 	}
-	return pq.pm[0], true
+	return pq.requeueTop(fn(pq.pm[0], pq.pv[0])), true
 }
 
-// PeekValue returns the highest priority value from the priority queue.
-// It returns false as its last return value if the priority queue is empty; otherwise, true.
-func (pq *KeyedPriorityQueue[K, V]) PeekValue() (V, bool) {
-	pq.mu.RLock()
-	defer pq.mu.RUnlock()
-
-	if len(pq.pm) == 0 {
-		var v V
-		return v, false
+// requeueTop pushes the highest priority key of the non-empty heap back with the given value v,
+// as if it was popped and pushed again, returning the key.
+func (pq *KeyedPriorityQueue[K, V]) requeueTop(v V) K {
+	k := pq.pm[0]
+	if pq.seq != nil {
+		pq.seq[k] = pq.nextSeq
+		pq.nextSeq++
+	}
+	pq.setValue(0, v)
+	pq.sink(0, len(pq.pm))
+	pq.settle()
+	if pq.metrics != nil {
+		pq.metrics.IncPop()
+		pq.metrics.IncPush()
 	}
-	return pq.vals[pq.pm[0]], true
+	return k
 }
 
-// Contains returns true if the given key k exists in the priority queue; otherwise, false.
-func (pq *KeyedPriorityQueue[K, V]) Contains(k K) bool {
-	pq.mu.RLock()
-	defer pq.mu.RUnlock()
+// PopLess pops the entries of the priority queue whose priority value compares before threshold,
+// i.e., for which cmp(v, threshold) is true, stopping at the first entry that doesn't, which is left in the priority queue.
+// It returns the popped entries in priority order, or nil if no entry was popped.
+// It's like PopUntil with a threshold instead of an arbitrary predicate, e.g., for draining all the entries
+// whose priority values are below a level.
+func (pq *KeyedPriorityQueue[K, V]) PopLess(threshold V) []Item[K, V] {
+	pq.mu.Lock()
+	defer pq.unlock()
 
-	_, ok := pq.im[k]
-	return ok
+	var items []Item[K, V]
+	for len(pq.pm) > 0 && pq.cmp(pq.pv[0], threshold) {
+		k, v := pq.removeAt(0)
+		pq.evict(k, v, EvictPop)
+		pq.settle()
+		items = append(items, Item[K, V]{Key: k, Value: v})
+	}
+	pq.autoPurge()
+	pq.shrink()
+	return items
 }
 
-// ValueOf returns the priority value associated with the given key k.
-// It returns false as its last return value if there's no such key k
-// in the priority queue; otherwise, true.
-func (pq *KeyedPriorityQueue[K, V]) ValueOf(k K) (V, bool) {
-	pq.mu.RLock()
-	defer pq.mu.RUnlock()
+// PopFunc removes and returns the highest priority entry of the priority queue for which pred returns true.
+// It returns the zero Item and false if there's no such entry; otherwise, true.
+// It suits dispatching the highest priority eligible entry, while leaving the ineligible ones in place.
+//
+// Like PeekFunc, it scans all the entries, so it has O(n) time complexity.
+// pred is called while holding the priority queue lock, so it must not call any of its methods.
+func (pq *KeyedPriorityQueue[K, V]) PopFunc(pred func(k K, v V) bool) (Item[K, V], bool) {
+	pq.mu.Lock()
+	defer pq.unlock()
 
-	v, ok := pq.vals[k]
-	return v, ok
+	i := pq.find(pred)
+	if i < 0 {
+		return Item[K, V]{}, false
+	}
+	k, v := pq.removeAt(i)
+	pq.evict(k, v, EvictPop)
+	pq.settle()
+	pq.autoPurge()
+	pq.shrink()
+	return Item[K, V]{Key: k, Value: v}, true
 }
 
-// Remove removes the priority value associated with the given key k from the priority queue.
-// It's a no-op if there's no such key k in the priority queue.
-func (pq *KeyedPriorityQueue[K, V]) Remove(k K) {
+// PopUntil pops the entries of the priority queue while pred returns true for the highest priority one,
+// stopping at the first entry for which pred returns false, which is left in the priority queue.
+// It returns the popped entries in priority order, or nil if no entry was popped.
+// Since entries are popped in priority order, it suits processing all the entries that are due,
+// e.g., when priority values are deadlines.
+//
+// The entries are popped atomically, and pred is called while holding the priority queue lock,
+// so it must not call any of its methods.
+func (pq *KeyedPriorityQueue[K, V]) PopUntil(pred func(k K, v V) bool) []Item[K, V] {
 	pq.mu.Lock()
-	defer pq.mu.Unlock()
+	defer pq.unlock()
 
-	i, ok := pq.im[k]
+	var items []Item[K, V]
+	for len(pq.pm) > 0 && pred(pq.pm[0], pq.pv[0]) {
+		k, v := pq.removeAt(0)
+		pq.evict(k, v, EvictPop)
+		pq.settle()
+		items = append(items, Item[K, V]{Key: k, Value: v})
+	}
+	pq.autoPurge()
+	pq.shrink()
+	return items
+}
+
+// MustPush is like Push but panics if the key already exists in the priority queue,
+// or if the priority queue is full and was created with the WithMaxSize option without eviction.
+// It's intended for cases where a duplicate key is a programming error, such as initialization code.
+func (pq *KeyedPriorityQueue[K, V]) MustPush(k K, v V) {
+	if err := pq.Push(k, v); err != nil {
+		panic(err)
+	}
+}
+
+// MustPop is like Pop but panics if the priority queue is empty.
+// It's intended for cases where popping from an empty priority queue is a programming error.
+func (pq *KeyedPriorityQueue[K, V]) MustPop() (K, V) {
+	k, v, ok := pq.Pop()
 	if !ok {
-		return
+		panic("keyed priority queue: MustPop called on an empty priority queue")
 	}
-	n := len(pq.pm) - 1
-	if i != n {
-		pq.swap(i, n)
-		pq.sink(i, n)
-		pq.swim(i)
+	return k, v
+}
+
+// DrainParallel pops all the entries of the priority queue from a pool of workers goroutines that call fn with each of them.
+// It blocks until the priority queue is empty and all the calls to fn have returned.
+//
+// Entries are popped in priority order, one at a time and only when a worker is ready to process it,
+// so entries waiting for a worker stay in the priority queue, and entries pushed while draining are popped too,
+// in their priority order.
+// However, since workers run concurrently, calls to fn may run or complete out of priority order.
+// The priority queue lock isn't held while calling fn, so fn may call methods of the priority queue.
+//
+// DrainParallel will panic if workers is not positive.
+func (pq *KeyedPriorityQueue[K, V]) DrainParallel(workers int, fn func(k K, v V)) {
+	if workers <= 0 {
+		panic("keyed priority queue: number of workers must be positive")
 	}
-	pq.pm = pq.pm[:n]
-	delete(pq.im, k)
-	delete(pq.vals, k)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				k, v, ok := pq.Pop()
+				if !ok {
+					return
+				}
+				fn(k, v)
+			}
+		}()
+	}
+	wg.Wait()
 }
 
-// Len returns the size of the priority queue.
-func (pq *KeyedPriorityQueue[K, V]) Len() int {
-	pq.mu.RLock()
-	defer pq.mu.RUnlock()
+// DrainToChannel pops as many entries as the priority queue holds when it's called, in priority order,
+// sending each of them to ch, and returns once they're all sent. It doesn't close ch, which is owned by the caller.
+// It blocks while ch isn't ready to receive, without holding the priority queue lock.
+//
+// Entries are popped one at a time, right before being sent, so entries pushed concurrently while draining
+// may be popped and sent in place of the ones present at the call if they have a higher priority,
+// and entries popped concurrently by other goroutines make DrainToChannel return early, once the priority queue is empty.
+func (pq *KeyedPriorityQueue[K, V]) DrainToChannel(ch chan<- Item[K, V]) {
+	for n := pq.Len(); n > 0; n-- {
+		k, v, ok := pq.Pop()
+		if !ok {
+			return
+		}
+		ch <- Item[K, V]{Key: k, Value: v}
+	}
+}
+
+// PopInto is like Pop, but it stores the removed key and value into the ones pointed to by k and v.
+// Either k or v may be nil, in which case the corresponding result is discarded.
+// It returns false if the priority queue is empty, leaving the pointed-to variables unchanged; otherwise, true.
+func (pq *KeyedPriorityQueue[K, V]) PopInto(k *K, v *V) bool {
+	key, val, ok := pq.Pop()
+	if !ok {
+		return false
+	}
+	if k != nil {
+		*k = key
+	}
+	if v != nil {
+		*v = val
+	}
+	return true
+}
 
-	return len(pq.pm)
+// Poll is like Pop, but it returns the removed key and value grouped in a single Item.
+// It returns the zero Item and false if the priority queue is empty; otherwise, true.
+func (pq *KeyedPriorityQueue[K, V]) Poll() (Item[K, V], bool) {
+	k, v, ok := pq.Pop()
+	return Item[K, V]{Key: k, Value: v}, ok
 }
 
-// IsEmpty returns true if the priority queue is empty; otherwise, false.
-func (pq *KeyedPriorityQueue[K, V]) IsEmpty() bool {
-	pq.mu.RLock()
-	defer pq.mu.RUnlock()
+// Set inserts a new entry in the priority queue with the given key and value,
+// if the key is not present in it; otherwise, it updates the priority value associated with the given key.
+// If the priority queue is full and was created with the WithMaxSize option without eviction,
+// Set panics with a CapacityExceededError error on a new key; use Push to handle that case.
+func (pq *KeyedPriorityQueue[K, V]) Set(k K, v V) {
+	pq.SetAndGet(k, v)
+}
+
+// SetAndGet is like Set, but it returns the previous priority value associated with the given key k,
+// and whether the key existed in the priority queue before.
+// If it didn't, old is the zero value of V.
+// The lookup and the update happen atomically.
+// Like Set, it panics with a CapacityExceededError error if a new key doesn't fit in a full priority queue
+// created with the WithMaxSize option without eviction.
+func (pq *KeyedPriorityQueue[K, V]) SetAndGet(k K, v V) (old V, existed bool) {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	if i, ok := pq.index(k); ok {
+		old = pq.pv[i]
+		pq.update(v, i)
+		return old, true
+	}
 
-	return len(pq.pm) == 0
+	if err := pq.push(k, v); err != nil {
+		panic(err)
+	}
+	return old, false
 }
 
-func (pq *KeyedPriorityQueue[K, V]) swap(i, j int) {
-	pq.pm[i], pq.pm[j] = pq.pm[j], pq.pm[i]
-	pq.im[pq.pm[i]], pq.im[pq.pm[j]] = i, j
+// Update changes the priority value associated with the given key k to the given value v.
+// If there's no key k in the priority queue, it returns a KeyNotFoundError error.
+func (pq *KeyedPriorityQueue[K, V]) Update(k K, v V) error {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	return pq.updateKey(k, v)
 }
 
-func (pq *KeyedPriorityQueue[K, V]) swim(i int) {
-	for i > 0 && pq.compare(i, parent(i)) {
-		pq.swap(i, parent(i))
-		i = parent(i)
+// UpdateReport is like Update, but it also reports whether the update changed the highest priority entry,
+// i.e., whether the highest priority key differs after the update, or the update succeeded and k is
+// the highest priority key afterwards, since its priority value was changed.
+func (pq *KeyedPriorityQueue[K, V]) UpdateReport(k K, v V) (topChanged bool, err error) {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	return pq.watchTop(k, func() error {
+		return pq.updateKey(k, v)
+	})
+}
+
+// updateKey implements Update.
+func (pq *KeyedPriorityQueue[K, V]) updateKey(k K, v V) error {
+	i, ok := pq.index(k)
+	if !ok {
+		return newKeyNotFoundError(k)
 	}
+
+	pq.update(v, i)
+	return nil
 }
 
-func (pq *KeyedPriorityQueue[K, V]) sink(i, n int) {
-	for leftChild(i) < n {
-		j := leftChild(i)
-		if j < 0 { // j < 0 after int overflow
-			break
-		}
-		if r := j + 1; r < n && pq.compare(r, j) {
-			j = r // r == j + 1 == right child
-		}
-		if !pq.compare(j, i) {
-			break
-		}
-		pq.swap(i, j)
-		i = j
+// UpdateIf changes the priority value associated with the given key k to the given value v,
+// only if cond(old, v) returns true, where old is the current priority value associated with k.
+// It returns true if the priority value was updated; otherwise, false.
+// If there's no key k in the priority queue, it returns a KeyNotFoundError error.
+//
+// cond is called while holding the priority queue lock, so it must not call any of its methods.
+func (pq *KeyedPriorityQueue[K, V]) UpdateIf(k K, v V, cond func(old, new V) bool) (bool, error) {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	i, ok := pq.index(k)
+	if !ok {
+		return false, newKeyNotFoundError(k)
 	}
+	if !cond(pq.pv[i], v) {
+		return false, nil
+	}
+
+	pq.update(v, i)
+	return true, nil
 }
 
-func (pq *KeyedPriorityQueue[K, V]) compare(i, j int) bool {
-	return pq.cmp(pq.vals[pq.pm[i]], pq.vals[pq.pm[j]])
+// CompareAndUpdate changes the priority value associated with the given key k to new,
+// only if its current priority value equals expected according to the eq function.
+// It returns true if the priority value was swapped; otherwise, false.
+// If there's no key k in the priority queue, it returns a KeyNotFoundError error.
+// The comparison and the update happen atomically, allowing optimistic concurrency patterns.
+//
+// eq is called while holding the priority queue lock, so it must not call any of its methods.
+func (pq *KeyedPriorityQueue[K, V]) CompareAndUpdate(k K, expected, new V, eq func(a, b V) bool) (bool, error) {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	i, ok := pq.index(k)
+	if !ok {
+		return false, newKeyNotFoundError(k)
+	}
+	if !eq(pq.pv[i], expected) {
+		return false, nil
+	}
+
+	pq.update(new, i)
+	return true, nil
+}
+
+// Adjust changes the priority value associated with the given key k to combine(old, delta),
+// where old is its current priority value, e.g., to nudge it up or down by a fixed amount.
+// It generalizes increasing and decreasing priority values for types whose arithmetic is user-defined.
+// If there's no key k in the priority queue, it returns a KeyNotFoundError error.
+//
+// combine is called while holding the priority queue lock, so it must not call any of its methods.
+func (pq *KeyedPriorityQueue[K, V]) Adjust(k K, delta V, combine func(old, delta V) V) error {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	i, ok := pq.index(k)
+	if !ok {
+		return newKeyNotFoundError(k)
+	}
+
+	pq.update(combine(pq.pv[i], delta), i)
+	return nil
+}
+
+// Touch changes the priority value associated with the given key k to the value returned by fn,
+// e.g., to mark it as the most recently used one by setting its priority value to the current time.
+// fn is only called if the key k exists, which avoids computing a value that would be discarded.
+// If there's no key k in the priority queue, it returns a KeyNotFoundError error.
+//
+// fn is called while holding the priority queue lock, so it must not call any of its methods.
+func (pq *KeyedPriorityQueue[K, V]) Touch(k K, fn func() V) error {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	i, ok := pq.index(k)
+	if !ok {
+		return newKeyNotFoundError(k)
+	}
+
+	pq.update(fn(), i)
+	return nil
+}
+
+// Fix restores the heap ordering for the key k after its priority value was mutated in place,
+// e.g., when V is a pointer whose pointee changed, moving the entry up or down as needed based on its current value.
+// If there's no key k in the priority queue, it returns a KeyNotFoundError error.
+//
+// Mutating a priority value in place without calling Fix leaves the priority queue in an inconsistent state,
+// much like container/heap.Fix is needed after changing an element of a heap.Interface.
+func (pq *KeyedPriorityQueue[K, V]) Fix(k K) error {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	i, ok := pq.index(k)
+	if !ok {
+		return newKeyNotFoundError(k)
+	}
+
+	// refreshes the cached sort key, if any, from the mutated value.
+	pq.setValue(i, pq.pv[i])
+	pq.swim(i)
+	pq.sink(pq.im[k], len(pq.pm))
+	pq.settle()
+	return nil
+}
+
+// Reheapify restores the heap ordering of the whole priority queue after many of its priority values
+// were mutated in place, rebuilding the heap bottom-up in O(n) time.
+// It's cheaper than calling Fix for each mutated key when a large part of the priority queue changed.
+func (pq *KeyedPriorityQueue[K, V]) Reheapify() {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	if pq.keys != nil {
+		for i, v := range pq.pv {
+			pq.keys.set(i, v)
+		}
+	}
+	pq.heapify()
+	pq.settle()
+}
+
+// ReplaceValues replaces the priority value of every key of the priority queue with the one given for it in vals,
+// restoring the heap ordering once in O(n) time, which is faster than updating each key in turn.
+// The keys of vals must be exactly the keys of the priority queue: if a key of the priority queue is missing from vals,
+// it returns a MissingValueError error; if a key of vals isn't in the priority queue, it returns a KeyNotFoundError error.
+// In both cases, the error names the first mismatching key found, and the priority queue is left untouched.
+func (pq *KeyedPriorityQueue[K, V]) ReplaceValues(vals map[K]V) error {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	for _, k := range pq.pm {
+		if _, ok := vals[k]; !ok && !pq.isDead(k) {
+			return newMissingValueError(k)
+		}
+	}
+	if len(vals) != pq.Len() {
+		// every key of the priority queue is in vals, so some keys of vals aren't in the priority queue.
+		for k := range vals {
+			if _, ok := pq.index(k); !ok {
+				return newKeyNotFoundError(k)
+			}
+		}
+	}
+
+	for i, k := range pq.pm {
+		if !pq.isDead(k) {
+			pq.setValue(i, vals[k])
+			if pq.metrics != nil {
+				pq.metrics.IncUpdate()
+			}
+		}
+	}
+	pq.heapify()
+	pq.settle()
+	return nil
+}
+
+// Repair restores the consistency of a priority queue suspected of corruption, e.g., by a buggy in-place mutation
+// of its priority values, returning the number of heap positions that changed, i.e., that hold a different key
+// afterwards, or whose key wasn't indexed at that position.
+// It rebuilds the index of the keys from the heap, and then the heap itself bottom-up, in O(n) time.
+// A consistent priority queue, i.e., one for which CheckInvariant returns nil, is left unchanged, and 0 is returned.
+//
+// It's meant as a recovery routine for long-running processes: the ordering of priority values mutated
+// in place is lost anyway, and duplicated keys, e.g., from the WithoutDuplicateCheck option, can't be repaired.
+func (pq *KeyedPriorityQueue[K, V]) Repair() int {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	before := make([]K, len(pq.pm))
+	copy(before, pq.pm)
+	drifted := make([]bool, len(pq.pm))
+	for i, k := range pq.pm {
+		if j, ok := pq.im[k]; !ok || j != i {
+			drifted[i] = true
+		}
+	}
+
+	pq.im = make(map[K]int, len(pq.pm))
+	for i, k := range pq.pm {
+		pq.im[k] = i
+	}
+	if pq.keys != nil {
+		pq.rekey(cap(pq.pm))
+	}
+	pq.size.Store(int64(len(pq.pm) - len(pq.dead)))
+	pq.heapify()
+
+	var changed int
+	for i, k := range pq.pm {
+		if drifted[i] || k != before[i] {
+			changed++
+		}
+	}
+	pq.settle()
+	return changed
+}
+
+// RenameKey replaces the key old with the key new in the priority queue,
+// preserving its priority value and its position in the priority queue.
+// If there's no key old in the priority queue, it returns a KeyNotFoundError error;
+// if the key new already exists in it, it returns a KeyAlreadyExistsError error.
+// Renaming a key to itself is a no-op.
+func (pq *KeyedPriorityQueue[K, V]) RenameKey(old, new K) error {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	if _, ok := pq.index(old); !ok {
+		return newKeyNotFoundError(old)
+	}
+	if old == new {
+		return nil
+	}
+	if _, ok := pq.index(new); ok {
+		return newKeyAlreadyExistsError(new)
+	}
+	if j, ok := pq.im[new]; ok {
+		pq.removeAt(j) // new was removed lazily; drop its tombstone first
+		pq.settle()
+	}
+
+	i := pq.im[old]
+	pq.pm[i] = new
+	pq.im[new] = i
+	delete(pq.im, old)
+	if pq.seq != nil {
+		pq.seq[new] = pq.seq[old]
+		delete(pq.seq, old)
+	}
+	return nil
+}
+
+// SwapPriorities exchanges the priority values associated with the given keys a and b.
+// If either key doesn't exist in the priority queue, it returns a KeyNotFoundError error
+// and the priority queue is left unchanged.
+// Swapping the priority value of a key with itself is a no-op.
+func (pq *KeyedPriorityQueue[K, V]) SwapPriorities(a, b K) error {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	i, ok := pq.index(a)
+	if !ok {
+		return newKeyNotFoundError(a)
+	}
+	if _, ok := pq.index(b); !ok {
+		return newKeyNotFoundError(b)
+	}
+	if a == b {
+		return nil
+	}
+
+	va, vb := pq.pv[i], pq.pv[pq.im[b]]
+	// one of the values gains priority, so both directions must be considered even for monotone updates.
+	pq.resift(vb, i)
+	pq.resift(va, pq.im[b])
+	if pq.metrics != nil {
+		pq.metrics.IncUpdate()
+		pq.metrics.IncUpdate()
+	}
+	return nil
+}
+
+// DecreaseKey changes the priority value associated with the given key k to the given value v,
+// where v must have a priority higher than or equal to the current one, i.e., cmp(current, v) is false.
+// Unlike Update, it only moves the key up in the priority queue.
+// If there's no key k in the priority queue, it returns a KeyNotFoundError error.
+//
+// DecreaseKey will panic if v has a lower priority than the current value.
+func (pq *KeyedPriorityQueue[K, V]) DecreaseKey(k K, v V) error {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	i, ok := pq.index(k)
+	if !ok {
+		return newKeyNotFoundError(k)
+	}
+	if pq.cmp(pq.pv[i], v) {
+		panic("keyed priority queue: DecreaseKey called with a lower priority value")
+	}
+	if pq.metrics != nil {
+		pq.metrics.IncUpdate()
+	}
+
+	pq.setValue(i, v)
+	pq.swim(i)
+	return nil
+}
+
+// IncreaseKey changes the priority value associated with the given key k to the given value v,
+// where v must have a priority lower than or equal to the current one, i.e., cmp(v, current) is false.
+// Unlike Update, it only moves the key down in the priority queue.
+// If there's no key k in the priority queue, it returns a KeyNotFoundError error.
+//
+// IncreaseKey will panic if v has a higher priority than the current value.
+func (pq *KeyedPriorityQueue[K, V]) IncreaseKey(k K, v V) error {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	i, ok := pq.index(k)
+	if !ok {
+		return newKeyNotFoundError(k)
+	}
+	if pq.cmp(v, pq.pv[i]) {
+		panic("keyed priority queue: IncreaseKey called with a higher priority value")
+	}
+	if pq.metrics != nil {
+		pq.metrics.IncUpdate()
+	}
+
+	pq.setValue(i, v)
+	pq.sink(i, len(pq.pm))
+	pq.settle()
+	return nil
+}
+
+// setValue sets the priority value of the entry at the position i of the heap to v, without restoring the heap ordering.
+func (pq *KeyedPriorityQueue[K, V]) setValue(i int, v V) {
+	pq.pv[i] = v
+	if pq.keys != nil {
+		pq.keys.set(i, v)
+	}
+}
+
+// value returns the priority value of the entry at the position i of the heap to be handed out by a lookup,
+// copied if the priority queue was created with the WithValueCopier option.
+func (pq *KeyedPriorityQueue[K, V]) value(i int) V {
+	if pq.copier != nil {
+		return pq.copier(pq.pv[i])
+	}
+	return pq.pv[i]
+}
+
+// update changes the priority value of the entry at the position i of the heap to v, restoring the heap ordering.
+// If the priority queue was created with WithMonotoneIncreasing, v is assumed not to have a higher priority
+// than the current value, so the entry is only sunk.
+func (pq *KeyedPriorityQueue[K, V]) update(v V, i int) {
+	if pq.metrics != nil {
+		pq.metrics.IncUpdate()
+	}
+	if pq.refresh && pq.seq != nil {
+		// a later sequence only lowers the priority of the entry, which resift and sink handle.
+		pq.seq[pq.pm[i]] = pq.nextSeq
+		pq.nextSeq++
+	}
+	if pq.monotone {
+		pq.setValue(i, v)
+		pq.sink(i, len(pq.pm))
+		pq.settle()
+		return
+	}
+	pq.resift(v, i)
+}
+
+// resift changes the priority value of the entry at the position i of the heap to v,
+// moving the entry up or down as needed.
+func (pq *KeyedPriorityQueue[K, V]) resift(v V, i int) {
+	old := pq.pv[i]
+	pq.setValue(i, v)
+	// only one direction needs sifting: up if v has a higher priority than
+	// the old value; down otherwise.
+	if pq.cmp(v, old) {
+		pq.swim(i)
+		return
+	}
+	pq.sink(i, len(pq.pm))
+	pq.settle()
+}
+
+// Peek returns the highest priority key and value from the priority queue.
+// It returns false as its last return value if the priority queue is empty; otherwise, true.
+func (pq *KeyedPriorityQueue[K, V]) Peek() (K, V, bool) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	if len(pq.pm) == 0 {
+		var k K
+		var v V
+		return k, v, false
+	}
+	return pq.pm[0], pq.value(0), true
+}
+
+// PeekWithLen is like Peek, but it also returns the size of the priority queue,
+// read under the same lock as the highest priority key and value, so that both are consistent with each other.
+// Calling Peek and Len separately may observe a mutation in between.
+func (pq *KeyedPriorityQueue[K, V]) PeekWithLen() (K, V, int, bool) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	if len(pq.pm) == 0 {
+		var k K
+		var v V
+		return k, v, 0, false
+	}
+	return pq.pm[0], pq.value(0), pq.Len(), true
+}
+
+// PeekItem is like Peek, but it returns the highest priority key and value grouped in a single Item.
+// It returns the zero Item and false if the priority queue is empty; otherwise, true.
+func (pq *KeyedPriorityQueue[K, V]) PeekItem() (Item[K, V], bool) {
+	k, v, ok := pq.Peek()
+	return Item[K, V]{Key: k, Value: v}, ok
+}
+
+// PeekAt returns the entry at the given rank in priority order, where rank 0 is the highest priority entry,
+// without removing any entry from the priority queue.
+// It returns false as its last return value if rank is out of bounds; otherwise, true.
+//
+// It explores the heap from its top, keeping the candidates for the next rank in an auxiliary heap,
+// so it has O(rank log rank) time complexity and it's cheap for small ranks.
+func (pq *KeyedPriorityQueue[K, V]) PeekAt(rank int) (Item[K, V], bool) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	n := len(pq.pm)
+	if rank < 0 || rank >= pq.Len() {
+		return Item[K, V]{}, false
+	}
+
+	// cand holds the positions whose parents have been visited, ordered as the priority queue.
+	cand := []int{0}
+	for {
+		i := cand[0]
+		last := len(cand) - 1
+		cand[0] = cand[last]
+		cand = cand[:last]
+		down(cand, 0, len(cand), pq.compare)
+
+		if k := pq.pm[i]; !pq.isDead(k) {
+			if rank == 0 {
+				return Item[K, V]{Key: k, Value: pq.pv[i]}, true
+			}
+			rank--
+		}
+
+		if !hasChild(i, n, pq.d) {
+			continue
+		}
+		j := leftChild(i, pq.d)
+		for c := j; c < n && c-j < pq.d; c++ {
+			cand = append(cand, c)
+			up(cand, len(cand)-1, pq.compare)
+		}
+	}
+}
+
+// PeekFunc returns the highest priority entry of the priority queue for which pred returns true,
+// without removing it. It returns the zero Item and false if there's no such entry; otherwise, true.
+// It suits finding the first eligible entry when the top one may not be,
+// without popping and pushing back the ineligible ones.
+//
+// PeekFunc scans all the entries, so it has O(n) time complexity.
+// pred is called while holding the priority queue read lock, so it must not call any of its methods.
+func (pq *KeyedPriorityQueue[K, V]) PeekFunc(pred func(k K, v V) bool) (Item[K, V], bool) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	i := pq.find(pred)
+	if i < 0 {
+		return Item[K, V]{}, false
+	}
+	return Item[K, V]{Key: pq.pm[i], Value: pq.pv[i]}, true
+}
+
+// find returns the position of the highest priority entry for which pred returns true, or -1 if there's none.
+func (pq *KeyedPriorityQueue[K, V]) find(pred func(k K, v V) bool) int {
+	best := -1
+	for i, k := range pq.pm {
+		if pq.isDead(k) || !pred(k, pq.pv[i]) {
+			continue
+		}
+		if best < 0 || pq.compare(i, best) {
+			best = i
+		}
+	}
+	return best
+}
+
+// MinMax returns both the highest priority entry, as min, and the lowest priority entry, as max,
+// i.e., the first and the last entries according to the comparison function.
+// It returns false as its last return value if the priority queue is empty; otherwise, true.
+//
+// While min is the top of the heap, max is found by scanning the leaves of the heap,
+// or all of its entries if some were removed lazily, so MinMax has O(n) time complexity.
+// It's cheaper than Items when only the extremes are needed.
+func (pq *KeyedPriorityQueue[K, V]) MinMax() (min Item[K, V], max Item[K, V], ok bool) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	if len(pq.pm) == 0 {
+		return min, max, false
+	}
+
+	w := -1
+	if len(pq.dead) == 0 {
+		w = pq.worst()
+	} else {
+		for i, k := range pq.pm {
+			if !pq.isDead(k) && (w < 0 || pq.compare(w, i)) {
+				w = i
+			}
+		}
+	}
+	min = Item[K, V]{Key: pq.pm[0], Value: pq.pv[0]}
+	max = Item[K, V]{Key: pq.pm[w], Value: pq.pv[w]}
+	return min, max, true
+}
+
+// Frontier returns the entries in the first depth levels of the heap, in heap order,
+// e.g., up to 3 entries for a depth of 2 in a binary heap.
+// They aren't sorted by priority, but the highest priority entry comes first,
+// and every entry of a level has a higher priority than its descendants in the deeper levels.
+// It suits bounded look-ahead over the entries near the top, without sorting the priority queue.
+// It returns nil if depth isn't positive or the priority queue is empty.
+//
+// It has O(d^depth) time complexity, where d is the arity of the heap.
+func (pq *KeyedPriorityQueue[K, V]) Frontier(depth int) []Item[K, V] {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	// n is the number of entries in the first depth levels, bounded by the size of the heap.
+	n, width := 0, 1
+	for l := 0; l < depth && n < len(pq.pm); l++ {
+		n += width
+		if width > len(pq.pm)/pq.d {
+			width = len(pq.pm) // the next level is wider than the heap
+		} else {
+			width *= pq.d
+		}
+	}
+	n = min(n, len(pq.pm))
+
+	var items []Item[K, V]
+	for i, k := range pq.pm[:n] {
+		if !pq.isDead(k) {
+			items = append(items, Item[K, V]{Key: k, Value: pq.pv[i]})
+		}
+	}
+	return items
+}
+
+// LevelOrder returns the entries of the priority queue grouped by the level of the heap tree they're at,
+// where the level 0 holds the highest priority entry, the level 1 its children, and so on;
+// each level holds up to d times the entries of the previous one, where d is the arity of the heap.
+// Within a level, the entries are in heap order, so that the children of an entry follow the children
+// of the entries before it. It suits rendering the heap structure, e.g., for visualization.
+// Keys removed lazily and not purged yet are left out of their levels.
+// It returns nil if the priority queue is empty.
+func (pq *KeyedPriorityQueue[K, V]) LevelOrder() [][]Item[K, V] {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	var levels [][]Item[K, V]
+	n := len(pq.pm)
+	for start, width := 0, 1; start < n; {
+		end := start + min(width, n-start)
+		level := make([]Item[K, V], 0, end-start)
+		for i, k := range pq.pm[start:end] {
+			if !pq.isDead(k) {
+				level = append(level, Item[K, V]{Key: k, Value: pq.pv[start+i]})
+			}
+		}
+		levels = append(levels, level)
+		start = end
+		if width > n/pq.d {
+			width = n // the next level is wider than the heap
+		} else {
+			width *= pq.d
+		}
+	}
+	return levels
+}
+
+// BottomN returns the n lowest priority entries of the priority queue, i.e., the ones that would be popped last,
+// ordered from the lowest priority up, so the entry that would be popped last comes first.
+// It returns all the entries if the priority queue holds fewer than n, and nil if n isn't positive.
+// The priority queue is left unchanged.
+//
+// Apart from the last one, the lowest priority entries may be anywhere in the heap, not only among its leaves,
+// so BottomN scans all the entries while keeping the n lowest priority ones in a bounded heap,
+// which has O(m log n) time complexity, where m is the size of the priority queue.
+func (pq *KeyedPriorityQueue[K, V]) BottomN(n int) []Item[K, V] {
+	if n <= 0 {
+		return nil
+	}
+
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	// bottom is a heap whose root is the highest priority entry among the n lowest priority ones found so far,
+	// i.e., the one to replace when a lower priority entry is found.
+	var bottom []Item[K, V]
+	for i, k := range pq.pm {
+		if pq.isDead(k) {
+			continue
+		}
+		item := Item[K, V]{Key: k, Value: pq.pv[i]}
+		switch {
+		case len(bottom) < n:
+			bottom = append(bottom, item)
+			up(bottom, len(bottom)-1, pq.lessItem)
+		case pq.lessItem(bottom[0], item):
+			bottom[0] = item
+			down(bottom, 0, n, pq.lessItem)
+		}
+	}
+
+	heapSort(bottom, func(a, b Item[K, V]) bool {
+		return pq.lessItem(b, a)
+	})
+	return bottom
+}
+
+// PeekKey returns the highest priority key from the priority queue.
+// It returns false as its last return value if the priority queue is empty; otherwise, true.
+func (pq *KeyedPriorityQueue[K, V]) PeekKey() (K, bool) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	if len(pq.pm) == 0 {
+		var k K
+		return k, false
+	}
+	return pq.pm[0], true
+}
+
+// PeekValue returns the highest priority value from the priority queue.
+// It returns false as its last return value if the priority queue is empty; otherwise, true.
+func (pq *KeyedPriorityQueue[K, V]) PeekValue() (V, bool) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	if len(pq.pm) == 0 {
+		var v V
+		return v, false
+	}
+	return pq.value(0), true
+}
+
+// WaitForTop blocks until the priority queue has a highest priority value satisfying ready, returning its key
+// and value without removing them, or until ctx is done, returning ctx.Err(). ready is only re-evaluated
+// after writes to the priority queue, while holding its lock, so it must not call any of its methods.
+// It can't be used with the WithoutLocking option.
+func (pq *KeyedPriorityQueue[K, V]) WaitForTop(ctx context.Context, ready func(v V) bool) (K, V, error) {
+	for {
+		pq.mu.Lock()
+		if len(pq.pm) > 0 && ready(pq.pv[0]) {
+			k, v := pq.pm[0], pq.value(0)
+			pq.mu.Unlock()
+			return k, v, nil
+		}
+		if pq.changed == nil {
+			pq.changed = make(chan struct{})
+		}
+		changed := pq.changed
+		// nothing was written, so the waiters mustn't be woken up by unlock.
+		pq.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			var k K
+			var v V
+			return k, v, ctx.Err()
+		}
+	}
+}
+
+// Contains returns true if the given key k exists in the priority queue; otherwise, false.
+func (pq *KeyedPriorityQueue[K, V]) Contains(k K) bool {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	_, ok := pq.index(k)
+	return ok
+}
+
+// ContainsAll returns true if all the given keys exist in the priority queue; otherwise, false.
+// It returns true if no keys are given.
+func (pq *KeyedPriorityQueue[K, V]) ContainsAll(keys ...K) bool {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	for _, k := range keys {
+		if _, ok := pq.index(k); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ContainsAny returns true if at least one of the given keys exists in the priority queue; otherwise, false.
+// It returns false if no keys are given.
+func (pq *KeyedPriorityQueue[K, V]) ContainsAny(keys ...K) bool {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	for _, k := range keys {
+		if _, ok := pq.index(k); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsEach returns a map with an entry for each of the given keys,
+// telling whether it exists in the priority queue.
+// All the keys are looked up atomically, so the results are consistent with each other.
+func (pq *KeyedPriorityQueue[K, V]) ContainsEach(keys []K) map[K]bool {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	res := make(map[K]bool, len(keys))
+	for _, k := range keys {
+		_, res[k] = pq.index(k)
+	}
+	return res
+}
+
+// ValueOf returns the priority value associated with the given key k.
+// It returns false as its last return value if there's no such key k
+// in the priority queue; otherwise, true.
+func (pq *KeyedPriorityQueue[K, V]) ValueOf(k K) (V, bool) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	i, ok := pq.index(k)
+	if !ok {
+		var v V
+		return v, false
+	}
+	return pq.value(i), true
+}
+
+// ValueOfMany returns a map with the priority values associated with the given keys.
+// Keys that don't exist in the priority queue are simply absent from the returned map.
+// All the keys are looked up atomically, so the values are consistent with each other.
+func (pq *KeyedPriorityQueue[K, V]) ValueOfMany(keys []K) map[K]V {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	res := make(map[K]V, len(keys))
+	for _, k := range keys {
+		if i, ok := pq.index(k); ok {
+			res[k] = pq.value(i)
+		}
+	}
+	return res
+}
+
+// IndexOf returns the position of the given key k in the heap backing the priority queue,
+// where 0 is the position of the highest priority key.
+// It returns false as its last return value if there's no such key k in the priority queue; otherwise, true.
+//
+// The position is an internal detail meant for debugging and visualization:
+// it's only meaningful until the next mutation of the priority queue.
+func (pq *KeyedPriorityQueue[K, V]) IndexOf(k K) (int, bool) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	return pq.index(k)
+}
+
+// GetOr returns the priority value associated with the given key k,
+// or the given default value def if there's no such key k in the priority queue.
+func (pq *KeyedPriorityQueue[K, V]) GetOr(k K, def V) V {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	if i, ok := pq.index(k); ok {
+		return pq.value(i)
+	}
+	return def
+}
+
+// Range returns the entries of the priority queue whose priority values are within the
+// inclusive range [lo, hi], as defined by the comparison function of the priority queue:
+// a value v is within the range if neither cmp(v, lo) nor cmp(hi, v) is true.
+// It scans all the entries, so it has O(n) time complexity, and the entries are returned in unspecified order.
+func (pq *KeyedPriorityQueue[K, V]) Range(lo, hi V) []Item[K, V] {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	var items []Item[K, V]
+	for i, k := range pq.pm {
+		if pq.isDead(k) {
+			continue
+		}
+		if v := pq.pv[i]; !pq.cmp(v, lo) && !pq.cmp(hi, v) {
+			items = append(items, Item[K, V]{Key: k, Value: v})
+		}
+	}
+	return items
+}
+
+// CountFunc returns the number of entries in the priority queue for which pred returns true.
+// It has O(n) time complexity.
+//
+// pred is called while holding the priority queue lock, so it must not call any of its methods.
+func (pq *KeyedPriorityQueue[K, V]) CountFunc(pred func(k K, v V) bool) int {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	var n int
+	for i, k := range pq.pm {
+		if !pq.isDead(k) && pred(k, pq.pv[i]) {
+			n++
+		}
+	}
+	return n
+}
+
+// DistinctValues returns the number of distinct priority values in the priority queue,
+// where two priority values are the same if eq returns true for them.
+// It has O(n*u) time complexity, where u is the number of distinct priority values,
+// since eq only allows comparing each value with the distinct ones found so far;
+// for comparable priority values, DistinctValuesComparable counts them in O(n) time.
+//
+// eq is called while holding the priority queue lock, so it must not call any of its methods.
+func (pq *KeyedPriorityQueue[K, V]) DistinctValues(eq func(a, b V) bool) int {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	var distinct []V
+next:
+	for i, k := range pq.pm {
+		if pq.isDead(k) {
+			continue
+		}
+		for _, d := range distinct {
+			if eq(d, pq.pv[i]) {
+				continue next
+			}
+		}
+		distinct = append(distinct, pq.pv[i])
+	}
+	return len(distinct)
+}
+
+// DistinctValuesComparable is like the DistinctValues method of pq for comparable priority values,
+// which are compared with ==, in O(n) time.
+func DistinctValuesComparable[K comparable, V comparable](pq *KeyedPriorityQueue[K, V]) int {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	distinct := make(map[V]struct{})
+	for i, k := range pq.pm {
+		if !pq.isDead(k) {
+			distinct[pq.pv[i]] = struct{}{}
+		}
+	}
+	return len(distinct)
+}
+
+// Remove removes the priority value associated with the given key k from the priority queue.
+// It's a no-op if there's no such key k in the priority queue.
+// Its signature is kept for compatibility: Pull also returns the removed priority value
+// and whether the key was present, without a separate call to ValueOf.
+//
+// If the priority queue was created with the WithLazyDeletion option,
+// the key is only marked as removed, unless it's the highest priority key.
+func (pq *KeyedPriorityQueue[K, V]) Remove(k K) {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	pq.remove(k)
+}
+
+// RemoveReport is like Remove, but it reports whether the removal changed the highest priority entry,
+// which is the case if and only if k was the highest priority key.
+func (pq *KeyedPriorityQueue[K, V]) RemoveReport(k K) (topChanged bool) {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	topChanged, _ = pq.watchTop(k, func() error {
+		pq.remove(k)
+		return nil
+	})
+	return topChanged
+}
+
+// watchTop calls op, which mutates the entry with the given key k, and reports whether it changed
+// the highest priority entry, along with the error returned by op.
+// The highest priority entry changes if its key isn't the same after calling op, including the priority queue
+// becoming empty or not empty, or if op succeeded and k is the highest priority key afterwards,
+// since its priority value may have changed.
+func (pq *KeyedPriorityQueue[K, V]) watchTop(k K, op func() error) (bool, error) {
+	before, hadTop := pq.top()
+	err := op()
+	after, hasTop := pq.top()
+	return hadTop != hasTop || before != after || (err == nil && hasTop && after == k), err
+}
+
+// top returns the highest priority key, which is always alive.
+// It returns false as its last return value if the priority queue is empty; otherwise, true.
+func (pq *KeyedPriorityQueue[K, V]) top() (K, bool) {
+	if len(pq.pm) == 0 {
+		var k K
+		return k, false
+	}
+	return pq.pm[0], true
+}
+
+// Pull removes the given key k from the priority queue, returning its priority value.
+// It returns false as its last return value if there's no such key k in the priority queue; otherwise, true.
+// Unlike calling ValueOf before Remove, the lookup and the removal happen atomically.
+func (pq *KeyedPriorityQueue[K, V]) Pull(k K) (V, bool) {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	return pq.remove(k)
+}
+
+func (pq *KeyedPriorityQueue[K, V]) remove(k K) (V, bool) {
+	i, ok := pq.index(k)
+	if !ok {
+		var v V
+		return v, false
+	}
+	v := pq.pv[i]
+	pq.evict(k, v, EvictRemove)
+	if pq.dead != nil && i != 0 {
+		pq.dead[k] = struct{}{}
+		pq.size.Add(-1)
+	} else {
+		pq.removeAt(i)
+		pq.settle()
+	}
+	pq.autoPurge()
+	pq.shrink()
+	return v, true
+}
+
+// evict records the eviction of the given key k and value v for the given reason,
+// to be reported to the eviction callback once the lock is released.
+func (pq *KeyedPriorityQueue[K, V]) evict(k K, v V, reason EvictReason) {
+	if pq.metrics != nil {
+		switch reason {
+		case EvictPop:
+			pq.metrics.IncPop()
+		case EvictRemove:
+			pq.metrics.IncRemove()
+		case EvictCapacity:
+			pq.metrics.IncEvict()
+		}
+	}
+	if pq.onEvict != nil {
+		pq.evicted = append(pq.evicted, eviction[K, V]{k: k, v: v, reason: reason})
+	}
+}
+
+// unlock releases the write lock of the priority queue,
+// and then reports the pending evictions to the eviction callback, if any.
+func (pq *KeyedPriorityQueue[K, V]) unlock() {
+	evicted, changed := pq.evicted, pq.changed
+	pq.evicted, pq.changed = nil, nil
+	pq.mu.Unlock()
+	notify(changed)
+	pq.report(evicted)
+}
+
+// notify wakes up the WaitForTop callers waiting on changed, if any.
+func notify(changed chan struct{}) {
+	if changed != nil {
+		close(changed)
+	}
+}
+
+// report calls the eviction callback for each of the given evictions.
+func (pq *KeyedPriorityQueue[K, V]) report(evicted []eviction[K, V]) {
+	for _, e := range evicted {
+		pq.onEvict(e.k, e.v, e.reason)
+	}
+}
+
+// lockPair write-locks both pq and other, which must be distinct, in a consistent order given by their addresses,
+// so that concurrent calls locking the same pair of priority queues in any order don't deadlock.
+// A lock shared by both priority queues through WithLocker is only taken once.
+// It returns a function that unlocks both priority queues, and then reports their pending evictions.
+func (pq *KeyedPriorityQueue[K, V]) lockPair(other *KeyedPriorityQueue[K, V]) func() {
+	first, second := pq, other
+	if uintptr(unsafe.Pointer(other)) < uintptr(unsafe.Pointer(pq)) {
+		first, second = other, pq
+	}
+
+	first.mu.Lock()
+	if first.mu == second.mu {
+		return func() {
+			evicted, changed := second.evicted, second.changed
+			second.evicted, second.changed = nil, nil
+			first.unlock()
+			notify(changed)
+			second.report(evicted)
+		}
+	}
+	second.mu.Lock()
+	return func() {
+		second.unlock()
+		first.unlock()
+	}
+}
+
+// removeAt removes the entry at the position i of the heap and returns its key and value.
+func (pq *KeyedPriorityQueue[K, V]) removeAt(i int) (K, V) {
+	n := len(pq.pm) - 1
+	k, v := pq.pm[i], pq.pv[i]
+	if i != n {
+		pq.swap(i, n)
+		pq.sink(i, n)
+		pq.swim(i)
+	}
+	var zero V
+	pq.pv[n] = zero // allow the value to be garbage collected
+	pq.pm, pq.pv = pq.pm[:n], pq.pv[:n]
+	delete(pq.im, k)
+	if pq.keys != nil {
+		pq.keys.truncate(n)
+	}
+	if pq.seq != nil {
+		delete(pq.seq, k)
+	}
+	if pq.isDead(k) {
+		delete(pq.dead, k) // already discounted from the size
+	} else {
+		pq.size.Add(-1)
+	}
+	return k, v
+}
+
+// index returns the position of the given key k in the heap.
+// It returns false as its last return value if there's no such key k,
+// or if it has been removed lazily; otherwise, true.
+func (pq *KeyedPriorityQueue[K, V]) index(k K) (int, bool) {
+	i, ok := pq.im[k]
+	if !ok || pq.isDead(k) {
+		return 0, false
+	}
+	return i, true
+}
+
+// isDead reports whether the given key k has been removed lazily.
+func (pq *KeyedPriorityQueue[K, V]) isDead(k K) bool {
+	_, ok := pq.dead[k]
+	return ok
+}
+
+// settle removes the tombstones from the top of the heap, so that its top entry is always alive.
+func (pq *KeyedPriorityQueue[K, V]) settle() {
+	for len(pq.dead) > 0 && len(pq.pm) > 0 && pq.isDead(pq.pm[0]) {
+		pq.removeAt(0)
+	}
+}
+
+// autoPurge purges the heap if its tombstones exceed the lazy deletion fraction of its size.
+func (pq *KeyedPriorityQueue[K, V]) autoPurge() {
+	if len(pq.dead) > 0 && float64(len(pq.dead)) > pq.deadRatio*float64(pq.size.Load()) {
+		pq.purge()
+	}
+}
+
+// purge compacts the heap, removing all of its tombstones in O(n) time.
+func (pq *KeyedPriorityQueue[K, V]) purge() {
+	if len(pq.dead) == 0 {
+		return
+	}
+	pm, pv := pq.pm[:0], pq.pv[:0]
+	for i, k := range pq.pm {
+		if pq.isDead(k) {
+			delete(pq.im, k)
+			if pq.seq != nil {
+				delete(pq.seq, k)
+			}
+			continue
+		}
+		if pq.keys != nil {
+			pq.keys.swap(len(pm), i) // len(pm) <= i, so no live sort key is overwritten
+		}
+		pq.im[k] = len(pm)
+		pm, pv = append(pm, k), append(pv, pq.pv[i])
+	}
+	clear(pq.pv[len(pv):]) // allow the purged values to be garbage collected
+	pq.pm, pq.pv = pm, pv
+	if pq.keys != nil {
+		pq.keys.truncate(len(pm))
+	}
+	pq.dead = make(map[K]struct{})
+	pq.heapify()
+}
+
+// heapify restores the heap ordering of all the entries in O(n) time.
+func (pq *KeyedPriorityQueue[K, V]) heapify() {
+	n := len(pq.pm)
+	if n < 2 {
+		return
+	}
+	for i := parent(n-1, pq.d); i >= 0; i-- {
+		pq.sink(i, n)
+	}
+}
+
+// worst returns the position of the lowest priority entry of the heap, which must not be empty.
+// Since it's one of the leaves, only the entries after the parent of the last one are scanned.
+func (pq *KeyedPriorityQueue[K, V]) worst() int {
+	n := len(pq.pm)
+	if n == 1 {
+		return 0
+	}
+	w := parent(n-1, pq.d) + 1
+	for i := w + 1; i < n; i++ {
+		if pq.compare(w, i) {
+			w = i
+		}
+	}
+	return w
+}
+
+// Len returns the size of the priority queue.
+// It reads the size atomically, without acquiring the priority queue lock.
+func (pq *KeyedPriorityQueue[K, V]) Len() int {
+	return int(pq.size.Load())
+}
+
+// IsEmpty returns true if the priority queue is empty; otherwise, false.
+// It reads the size atomically, without acquiring the priority queue lock.
+func (pq *KeyedPriorityQueue[K, V]) IsEmpty() bool {
+	return pq.size.Load() == 0
+}
+
+// Items returns all the entries of the priority queue in priority order,
+// from the highest to the lowest priority, i.e., the order in which they would be popped.
+// It returns nil if the priority queue is empty. The priority queue is left unchanged.
+func (pq *KeyedPriorityQueue[K, V]) Items() []Item[K, V] {
+	return pq.AppendSorted(nil)
+}
+
+// ItemsDesc is like Items, but it returns the entries in reverse priority order,
+// from the lowest to the highest priority.
+func (pq *KeyedPriorityQueue[K, V]) ItemsDesc() []Item[K, V] {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	var items []Item[K, V]
+	for i, k := range pq.pm {
+		if !pq.isDead(k) {
+			items = append(items, Item[K, V]{Key: k, Value: pq.pv[i]})
+		}
+	}
+	heapSort(items, func(a, b Item[K, V]) bool {
+		return pq.lessItem(b, a)
+	})
+	return items
+}
+
+// AppendKeys appends all the keys of the priority queue to dst in heap order, and returns the extended slice.
+// The highest priority key is appended first, but the remaining ones aren't sorted by priority.
+// Like AppendSorted, it follows the append idiom, so dst may be reused across calls to avoid allocations.
+func (pq *KeyedPriorityQueue[K, V]) AppendKeys(dst []K) []K {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	if len(pq.dead) == 0 {
+		return append(dst, pq.pm...)
+	}
+	for _, k := range pq.pm {
+		if !pq.isDead(k) {
+			dst = append(dst, k)
+		}
+	}
+	return dst
+}
+
+// AppendSorted appends all the entries of the priority queue to dst in priority order,
+// from the highest to the lowest priority, and returns the extended slice.
+// It follows the append idiom, so dst may be reused across calls to avoid allocations.
+// The priority queue is left unchanged.
+func (pq *KeyedPriorityQueue[K, V]) AppendSorted(dst []Item[K, V]) []Item[K, V] {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	n := len(dst)
+	for i, k := range pq.pm {
+		if !pq.isDead(k) {
+			dst = append(dst, Item[K, V]{Key: k, Value: pq.pv[i]})
+		}
+	}
+	heapSort(dst[n:], pq.lessItem)
+	return dst
+}
+
+// MapValues returns a new priority queue with the same keys and configuration as this priority queue,
+// where each priority value v associated with a key k is replaced by fn(k, v).
+// The heap of the new priority queue is built from scratch in O(n) time, and this priority queue is left unchanged.
+//
+// fn is called while holding the priority queue lock, so it must not call any of its methods.
+func (pq *KeyedPriorityQueue[K, V]) MapValues(fn func(k K, v V) V) *KeyedPriorityQueue[K, V] {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	mapped := pq.newLike(pq.Len())
+	for i, k := range pq.pm {
+		if pq.isDead(k) {
+			continue
+		}
+		mapped.add(k, fn(k, pq.pv[i]))
+		if pq.seq != nil {
+			mapped.seq[k] = pq.seq[k]
+		}
+	}
+	mapped.nextSeq = pq.nextSeq
+	mapped.heapify()
+	return mapped
+}
+
+// reset removes all the entries of the priority queue, making room for n entries.
+func (pq *KeyedPriorityQueue[K, V]) reset(n int) {
+	pq.pm = make([]K, 0, n)
+	pq.pv = make([]V, 0, n)
+	pq.im = make(map[K]int, n)
+	if pq.seq != nil {
+		pq.seq = make(map[K]uint64, n)
+	}
+	if pq.dead != nil {
+		pq.dead = make(map[K]struct{})
+	}
+	if pq.keys != nil {
+		pq.keys.reset(n)
+	}
+	pq.size.Store(0)
+}
+
+// load inserts the given items into the priority queue, which must not contain any of their keys.
+// Unless the priority queue is bounded, the heap is rebuilt once in O(n) time.
+// If it's bounded without eviction, the items that don't fit are discarded,
+// and reported to the eviction callback, if any, with the EvictCapacity reason.
+func (pq *KeyedPriorityQueue[K, V]) load(items []Item[K, V]) {
+	if pq.maxSize > 0 {
+		for _, item := range items {
+			if err := pq.push(item.Key, item.Value); err != nil {
+				pq.evict(item.Key, item.Value, EvictCapacity)
+			}
+		}
+		return
+	}
+	for _, item := range items {
+		pq.add(item.Key, item.Value)
+		if pq.metrics != nil {
+			pq.metrics.IncPush()
+		}
+	}
+	pq.heapify()
+}
+
+// Reversed returns a new priority queue with the same entries and configuration as this priority queue,
+// but ordered the other way around: its comparison function is cmp with swapped arguments,
+// so the lowest priority entry of this priority queue is the highest priority entry of the new one.
+// Among entries with equal priority values, the WithStableOrdering option still favors the ones pushed first.
+// The WithComparatorCaching and WithMonotoneIncreasing options aren't carried over,
+// since they depend on the direction of the ordering.
+// The heap of the new priority queue is built from scratch in O(n) time, and this priority queue is left unchanged.
+func (pq *KeyedPriorityQueue[K, V]) Reversed() *KeyedPriorityQueue[K, V] {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	rev := pq.newLike(pq.Len())
+	cmp := pq.cmp
+	rev.cmp = func(x, y V) bool {
+		return cmp(y, x)
+	}
+	if compare := pq.compare3; compare != nil {
+		rev.compare3 = func(x, y V) int {
+			return compare(y, x)
+		}
+	}
+	rev.keys = nil
+	rev.monotone = false
+
+	for i, k := range pq.pm {
+		if pq.isDead(k) {
+			continue
+		}
+		rev.add(k, pq.pv[i])
+		if pq.seq != nil {
+			rev.seq[k] = pq.seq[k]
+		}
+	}
+	rev.nextSeq = pq.nextSeq
+	rev.heapify()
+	return rev
+}
+
+// Partition removes the entries of the priority queue for which pred returns true,
+// and returns them in a new priority queue with the same configuration as this one.
+// The entries for which pred returns false are left in this priority queue.
+// Both heaps are rebuilt in O(n) time, and the moved entries aren't reported to the eviction callback.
+//
+// pred is called while holding the priority queue lock, so it must not call any of its methods.
+func (pq *KeyedPriorityQueue[K, V]) Partition(pred func(k K, v V) bool) *KeyedPriorityQueue[K, V] {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	part := pq.newLike(0)
+	pm, pv := pq.pm[:0], pq.pv[:0]
+	for i, k := range pq.pm {
+		v := pq.pv[i]
+		if pq.isDead(k) || pred(k, v) {
+			if !pq.isDead(k) {
+				part.add(k, v)
+				if pq.seq != nil {
+					part.seq[k] = pq.seq[k]
+				}
+				pq.size.Add(-1)
+			}
+			delete(pq.im, k)
+			if pq.seq != nil {
+				delete(pq.seq, k)
+			}
+			continue
+		}
+		if pq.keys != nil {
+			pq.keys.swap(len(pm), i) // len(pm) <= i, so no kept sort key is overwritten
+		}
+		pq.im[k] = len(pm)
+		pm, pv = append(pm, k), append(pv, v)
+	}
+	clear(pq.pv[len(pv):]) // allow the moved values to be garbage collected
+	pq.pm, pq.pv = pm, pv
+	if pq.keys != nil {
+		pq.keys.truncate(len(pm))
+	}
+	if pq.dead != nil {
+		pq.dead = make(map[K]struct{})
+	}
+	pq.heapify()
+	pq.shrink()
+
+	part.nextSeq = pq.nextSeq
+	part.heapify()
+	return part
+}
+
+// newLike returns a new empty priority queue with the same configuration as pq, and capacity for n entries.
+func (pq *KeyedPriorityQueue[K, V]) newLike(n int) *KeyedPriorityQueue[K, V] {
+	c := &KeyedPriorityQueue[K, V]{
+		mu:        new(sync.RWMutex),
+		pm:        make([]K, 0, n),
+		pv:        make([]V, 0, n),
+		im:        make(map[K]int, n),
+		cmp:       pq.cmp,
+		d:         pq.d,
+		compare3:  pq.compare3,
+		upsert:    pq.upsert,
+		monotone:  pq.monotone,
+		refresh:   pq.refresh,
+		copier:    pq.copier,
+		maxSize:   pq.maxSize,
+		reject:    pq.reject,
+		shrinkAt:  pq.shrinkAt,
+		headroom:  pq.headroom,
+		growth:    pq.growth,
+		deadRatio: pq.deadRatio,
+		onEvict:   pq.onEvict,
+		metrics:   pq.metrics,
+	}
+	if _, ok := pq.mu.(noLock); ok {
+		c.mu = noLock{}
+	}
+	if pq.seq != nil {
+		c.seq = make(map[K]uint64, n)
+	}
+	if pq.dead != nil {
+		c.dead = make(map[K]struct{})
+	}
+	if pq.keys != nil {
+		c.keys = pq.keys.clone(n)
+	}
+	return c
+}
+
+// CopyFrom replaces the entries of the priority queue with a copy of the entries of src, locking both of them,
+// and rebuilds its heap with its own comparison function and options in O(n) time.
+// Entries of src that exceed a WithMaxSize bound are evicted or discarded as if pushed one by one.
+func (pq *KeyedPriorityQueue[K, V]) CopyFrom(src *KeyedPriorityQueue[K, V]) {
+	if pq == src {
+		return
+	}
+	unlock := pq.lockPair(src)
+	defer unlock()
+
+	items := make([]Item[K, V], 0, src.Len())
+	for i, k := range src.pm {
+		if !src.isDead(k) {
+			items = append(items, Item[K, V]{Key: k, Value: src.pv[i]})
+		}
+	}
+
+	pq.reset(max(len(items), cap(pq.pm)))
+	pq.nextSeq = 0
+	pq.load(items)
+	if pq.seq != nil && src.seq != nil {
+		for k := range pq.seq {
+			pq.seq[k] = src.seq[k]
+		}
+		pq.nextSeq = src.nextSeq
+		pq.heapify()
+	}
+}
+
+// Swap exchanges the entries of the priority queue with the entries of other, locking both of them.
+// Each priority queue keeps its own comparison function and options, so both must order their priority values
+// the same way; the received entries are adapted to the other options in O(n) time, or else swapped in O(1) time.
+func (pq *KeyedPriorityQueue[K, V]) Swap(other *KeyedPriorityQueue[K, V]) {
+	if pq == other {
+		return
+	}
+	unlock := pq.lockPair(other)
+	defer unlock()
+
+	stable, otherStable := pq.seq != nil, other.seq != nil
+	lazy, otherLazy := pq.dead != nil, other.dead != nil
+
+	pq.pm, other.pm = other.pm, pq.pm
+	pq.pv, other.pv = other.pv, pq.pv
+	pq.im, other.im = other.im, pq.im
+	pq.seq, other.seq = other.seq, pq.seq
+	pq.nextSeq, other.nextSeq = other.nextSeq, pq.nextSeq
+	pq.dead, other.dead = other.dead, pq.dead
+	n := pq.size.Load()
+	pq.size.Store(other.size.Load())
+	other.size.Store(n)
+
+	pq.adapt(stable, lazy, other.d)
+	other.adapt(otherStable, otherLazy, pq.d)
+}
+
+// Transfer moves the key k along with its priority value from the priority queue from to the priority queue to,
+// holding the locks of both, so that the entry is never observed in neither or both of them.
+// If there's no key k in from, it returns a KeyNotFoundError error; if the key k already exists in to,
+// it returns a KeyAlreadyExistsError error, even if to was created with the WithUpsertPush option.
+// If to is full and was created with the WithMaxSize option, it returns a CapacityExceededError error,
+// unless to evicts entries and k has a higher priority than the lowest priority entry of to, which is then evicted.
+// The entry is left in from on error. Transferring a key from a priority queue to itself is a no-op.
+// The entry leaving from is reported to its eviction callback, if any, with the EvictRemove reason.
+func Transfer[K comparable, V any](from, to *KeyedPriorityQueue[K, V], k K) error {
+	if from == to {
+		if !from.Contains(k) {
+			return newKeyNotFoundError(k)
+		}
+		return nil
+	}
+	unlock := from.lockPair(to)
+	defer unlock()
+
+	i, ok := from.index(k)
+	if !ok {
+		return newKeyNotFoundError(k)
+	}
+	if _, ok := to.index(k); ok {
+		return newKeyAlreadyExistsError(k)
+	}
+	if !to.reject && to.maxSize > 0 && to.Len() >= to.maxSize {
+		// push would discard the entry, leaving it in neither priority queue.
+		to.purge()
+		if !to.cmp(from.pv[i], to.pv[to.worst()]) {
+			return newCapacityExceededError(k, to.maxSize)
+		}
+	}
+
+	if err := to.push(k, from.pv[i]); err != nil {
+		return err
+	}
+	from.remove(k)
+	return nil
+}
+
+// adapt makes the entries of the priority queue, taken from another one, consistent with its own options,
+// where stable and lazy tell whether it was created with WithStableOrdering and WithLazyDeletion, respectively,
+// and d is the arity of the heap the entries were taken from.
+func (pq *KeyedPriorityQueue[K, V]) adapt(stable, lazy bool, d int) {
+	switch {
+	case lazy && pq.dead == nil:
+		pq.dead = make(map[K]struct{})
+	case !lazy && pq.dead != nil:
+		pq.purge()
+		pq.dead = nil
+	}
+
+	switch {
+	case stable && pq.seq == nil:
+		pq.seq = make(map[K]uint64, cap(pq.pm))
+		// the sequences follow the heap order, so parents keep comparing before their children.
+		for _, k := range pq.pm {
+			pq.seq[k] = pq.nextSeq
+			pq.nextSeq++
+		}
+	case !stable && pq.seq != nil:
+		pq.seq = nil
+	}
+
+	if pq.keys != nil {
+		pq.rekey(cap(pq.pm))
+	}
+	if d != pq.d {
+		pq.heapify()
+	}
+}
+
+// Reserve ensures the priority queue can hold total entries in total,
+// so that pushing up to total entries doesn't reallocate its internal structures.
+// It's a no-op if the priority queue capacity already meets total; it never shrinks the priority queue.
+func (pq *KeyedPriorityQueue[K, V]) Reserve(total int) {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	if total <= cap(pq.pm) {
+		return
+	}
+	pq.realloc(total, true)
+}
+
+// TrimToSize releases the excess capacity of the priority queue,
+// reallocating its internal structures to fit exactly its current size.
+// Entries removed lazily are discarded.
+// The maps backing the priority queue are also rebuilt
+// when the priority queue holds less than half of its capacity,
+// since Go maps don't release memory after their entries are deleted.
+func (pq *KeyedPriorityQueue[K, V]) TrimToSize() {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	pq.purge()
+	n := len(pq.pm)
+	if n == cap(pq.pm) {
+		return
+	}
+	pq.realloc(n, n < cap(pq.pm)/2)
+}
+
+// Compact rebuilds the internal structures of the priority queue, sized to fit exactly its current size.
+// Unlike TrimToSize, it always allocates fresh maps and copies the live entries over,
+// shedding the memory retained by Go maps after many deletions, such as heavy use of Pop and Remove.
+// Entries removed lazily are discarded. It has O(n) time complexity.
+func (pq *KeyedPriorityQueue[K, V]) Compact() {
+	pq.mu.Lock()
+	defer pq.unlock()
+
+	pq.purge()
+	pq.realloc(len(pq.pm), true)
+}
+
+// CheckInvariant verifies the internal consistency of the priority queue,
+// returning a descriptive error on the first violation found; otherwise, nil.
+// It checks that no entry compares before its parent in the heap,
+// and that the internal structures backing the priority queue agree with each other.
+//
+// It's meant as a debugging and testing aid, e.g., to catch a comparison function
+// whose results change while entries are in the priority queue. It has O(n) time complexity.
+func (pq *KeyedPriorityQueue[K, V]) CheckInvariant() error {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	n := len(pq.pm)
+	if len(pq.pv) != n || len(pq.im) != n {
+		return fmt.Errorf("keyed priority queue: inconsistent sizes: %d positions, %d values and %d indexes", n, len(pq.pv), len(pq.im))
+	}
+	if pq.seq != nil && len(pq.seq) != n {
+		return fmt.Errorf("keyed priority queue: inconsistent sizes: %d sequence numbers for %d entries", len(pq.seq), n)
+	}
+	if live := int64(n - len(pq.dead)); pq.size.Load() != live {
+		return fmt.Errorf("keyed priority queue: size %d doesn't match the %d live entries", pq.size.Load(), live)
+	}
+	if n > 0 && pq.isDead(pq.pm[0]) {
+		return fmt.Errorf("keyed priority queue: top key \"%v\" has been removed", pq.pm[0])
+	}
+	for k := range pq.dead {
+		if _, ok := pq.im[k]; !ok {
+			return fmt.Errorf("keyed priority queue: removed key \"%v\" is not in the heap", k)
+		}
+	}
+
+	for i, k := range pq.pm {
+		if j, ok := pq.im[k]; !ok || j != i {
+			return fmt.Errorf("keyed priority queue: key \"%v\" at position %d is indexed at position %d", k, i, j)
+		}
+		if i == 0 {
+			continue
+		}
+		// with cached sort keys, the priority values are compared too, which catches stale sort keys.
+		p := parent(i, pq.d)
+		if pq.compare(i, p) || pq.keys != nil && pq.before(k, pq.pv[i], pq.pm[p], pq.pv[p]) {
+			return fmt.Errorf("keyed priority queue: key \"%v\" at position %d compares before its parent \"%v\" at position %d", k, i, pq.pm[p], p)
+		}
+	}
+	return nil
+}
+
+// QueueStats is a snapshot of the structural metrics of a priority queue, returned by its Stats method.
+type QueueStats struct {
+	Len            int // number of entries, as returned by Len
+	Cap            int // number of entries that fit before reallocating, as returned by Cap
+	Height         int // number of levels of the heap, including the tombstones; 0 if it's empty
+	TombstoneCount int // number of keys removed lazily and not purged yet; always 0 without lazy deletion
+}
+
+// Stats returns a consistent snapshot of the structural metrics of the priority queue,
+// e.g., for monitoring the health of its heap with a single call. It has O(log n) time complexity.
+func (pq *KeyedPriorityQueue[K, V]) Stats() QueueStats {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	// the leftmost path of the heap goes through all of its levels.
+	height := 0
+	if len(pq.pm) > 0 {
+		height = 1
+		for i := 0; hasChild(i, len(pq.pm), pq.d); i = leftChild(i, pq.d) {
+			height++
+		}
+	}
+	return QueueStats{
+		Len:            int(pq.size.Load()),
+		Cap:            cap(pq.pm),
+		Height:         height,
+		TombstoneCount: len(pq.dead),
+	}
+}
+
+// Cap returns the number of entries the priority queue can hold
+// before reallocating its internal structures.
+func (pq *KeyedPriorityQueue[K, V]) Cap() int {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	return cap(pq.pm)
+}
+
+// minShrinkCap is the capacity under which pm is never auto shrunk.
+const minShrinkCap = 16
+
+// shrink reallocates pm to its length times the headroom if auto shrinking is enabled
+// and the size of the priority queue dropped below the shrink ratio of its capacity.
+// Leaving room for more entries than the current size avoids growing pm right after shrinking it.
+func (pq *KeyedPriorityQueue[K, V]) shrink() {
+	n, c := len(pq.pm), cap(pq.pm)
+	if pq.shrinkAt == 0 || c <= minShrinkCap || float64(n) >= pq.shrinkAt*float64(c) {
+		return
+	}
+	if n = int(float64(n) * pq.headroom); n < minShrinkCap {
+		n = minShrinkCap
+	}
+	pq.realloc(n, false)
+}
+
+// grow reallocates pm to its capacity times the growth factor, making room for at least one more entry.
+func (pq *KeyedPriorityQueue[K, V]) grow() {
+	c := cap(pq.pm)
+	f := float64(c) * pq.growth
+	n := math.MaxInt
+	if f < math.MaxInt {
+		n = int(f)
+	}
+	if n <= c {
+		n = c + 1
+	}
+	pq.realloc(n, false)
+}
+
+// rekey rebuilds the cached sort keys of all the entries of the heap, sized to c.
+func (pq *KeyedPriorityQueue[K, V]) rekey(c int) {
+	pq.keys.reset(c)
+	for i, v := range pq.pv {
+		pq.keys.set(i, v)
+	}
+}
+
+// realloc reallocates pm and pv with capacity c, preserving the heap.
+// If maps is true, im and the other maps are also rebuilt sized to c.
+func (pq *KeyedPriorityQueue[K, V]) realloc(c int, maps bool) {
+	pm := make([]K, len(pq.pm), c)
+	copy(pm, pq.pm)
+	pv := make([]V, len(pq.pv), c)
+	copy(pv, pq.pv)
+	pq.pm, pq.pv = pm, pv
+	if pq.keys != nil {
+		pq.keys.realloc(c)
+	}
+
+	if !maps {
+		return
+	}
+
+	im := make(map[K]int, c)
+	for i, k := range pm {
+		im[k] = i
+	}
+	pq.im = im
+
+	if pq.seq != nil {
+		seq := make(map[K]uint64, c)
+		for _, k := range pm {
+			seq[k] = pq.seq[k]
+		}
+		pq.seq = seq
+	}
+}
+
+func (pq *KeyedPriorityQueue[K, V]) swap(i, j int) {
+	pq.pm[i], pq.pm[j] = pq.pm[j], pq.pm[i]
+	pq.pv[i], pq.pv[j] = pq.pv[j], pq.pv[i]
+	pq.im[pq.pm[i]], pq.im[pq.pm[j]] = i, j
+	if pq.keys != nil {
+		pq.keys.swap(i, j)
+	}
+}
+
+func (pq *KeyedPriorityQueue[K, V]) swim(i int) {
+	depth := 0
+	for i > 0 && pq.compare(i, parent(i, pq.d)) {
+		pq.swap(i, parent(i, pq.d))
+		i = parent(i, pq.d)
+		depth++
+	}
+	if pq.metrics != nil {
+		pq.metrics.ObserveSiftDepth(depth)
+	}
+}
+
+func (pq *KeyedPriorityQueue[K, V]) sink(i, n int) {
+	depth := 0
+	for hasChild(i, n, pq.d) {
+		first := leftChild(i, pq.d)
+		j := first
+		// pick the highest priority among the d children of i.
+		for r := first + 1; r < n && r-first < pq.d; r++ {
+			if pq.compare(r, j) {
+				j = r
+			}
+		}
+		if !pq.compare(j, i) {
+			break
+		}
+		pq.swap(i, j)
+		i = j
+		depth++
+	}
+	if pq.metrics != nil {
+		pq.metrics.ObserveSiftDepth(depth)
+	}
+}
+
+func (pq *KeyedPriorityQueue[K, V]) compare(i, j int) bool {
+	if pq.keys != nil {
+		if c := pq.keys.compare(i, j); c != 0 || pq.seq == nil {
+			return c < 0
+		}
+		return pq.seq[pq.pm[i]] < pq.seq[pq.pm[j]]
+	}
+	return pq.before(pq.pm[i], pq.pv[i], pq.pm[j], pq.pv[j])
+}
+
+func (pq *KeyedPriorityQueue[K, V]) lessItem(a, b Item[K, V]) bool {
+	return pq.before(a.Key, a.Value, b.Key, b.Value)
+}
+
+// before reports whether the entry with key ki and value vi has a higher priority
+// than the entry with key kj and value vj.
+// When the ordering is stable, ties are broken by the insertion sequence of the keys.
+func (pq *KeyedPriorityQueue[K, V]) before(ki K, vi V, kj K, vj V) bool {
+	if pq.seq == nil {
+		return pq.cmp(vi, vj)
+	}
+	if pq.compare3 != nil {
+		if c := pq.compare3(vi, vj); c != 0 {
+			return c < 0
+		}
+		return pq.seq[ki] < pq.seq[kj]
+	}
+	if pq.cmp(vi, vj) {
+		return true
+	}
+	if pq.cmp(vj, vi) {
+		return false
+	}
+	return pq.seq[ki] < pq.seq[kj]
+}
+
+// hasChild reports whether i has at least one child in a d-ary heap of size n.
+// Unlike comparing leftChild(i, d) with n, it can't overflow,
+// so it must be checked before calling leftChild.
+func hasChild(i, n, d int) bool {
+	return n >= 2 && i <= (n-2)/d
 }
 
-func leftChild(i int) int {
-	return (i * 2) + 1
+// leftChild returns the position of the first child of i in a d-ary heap.
+// It doesn't overflow as long as hasChild(i, n, d) holds for some valid heap size n.
+func leftChild(i, d int) int {
+	return (i * d) + 1
 }
 
-func parent(i int) int {
-	return (i - 1) / 2
+// parent returns the position of the parent of i in a d-ary heap.
+func parent(i, d int) int {
+	return (i - 1) / d
 }
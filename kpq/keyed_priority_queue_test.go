@@ -1,8 +1,12 @@
 package kpq
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
 	"testing"
 )
 
@@ -16,6 +20,122 @@ func TestNewKeyedPriorityQueue_NilCmp(t *testing.T) {
 	NewKeyedPriorityQueue[int, int](nil)
 }
 
+func TestNewKeyedPriorityQueueOrdered(t *testing.T) {
+	testCases := []struct {
+		name     string
+		less     CmpFunc[int]
+		wantKeys []string
+	}{
+		{name: "NilLess", wantKeys: []string{"first", "second", "third"}},
+		{name: "MaxLess", less: func(x, y int) bool { return x > y }, wantKeys: []string{"third", "second", "first"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := NewKeyedPriorityQueueOrdered[string](tc.less)
+			pq.MustPush("second", 2)
+			pq.MustPush("third", 3)
+			pq.MustPush("first", 1)
+
+			for _, want := range tc.wantKeys {
+				if k, _ := pq.MustPop(); k != want {
+					t.Errorf("pq.MustPop(): got key %q; want %q", k, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNewWithCompare(t *testing.T) {
+	pq := NewWithCompare[string](func(x, y int) int {
+		return y - x // max priority queue
+	})
+
+	for _, v := range []int{3, 9, 1, 7} {
+		if err := pq.Push(fmt.Sprint(v), v); err != nil {
+			t.Fatalf("pq.Push(%q, %d): got unexpected error %v", fmt.Sprint(v), v, err)
+		}
+	}
+
+	for _, want := range []int{9, 7, 3, 1} {
+		_, got, ok := pq.Pop()
+		if !ok {
+			t.Fatal("pq.Pop(): got unexpected empty priority queue")
+		}
+		if got != want {
+			t.Errorf("pq.Pop(): got value %d; want %d", got, want)
+		}
+	}
+}
+
+func TestNewWithCompare_NilCompare(t *testing.T) {
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("want NewWithCompare to panic when receiving a nil comparison function")
+		}
+	}()
+
+	NewWithCompare[int, int](nil)
+}
+
+func TestNewFromItems(t *testing.T) {
+	items := []Item[string, int]{
+		{Key: "fourth", Value: 10},
+		{Key: "second", Value: 8},
+		{Key: "third", Value: 9},
+		{Key: "first", Value: 6},
+		{Key: "last", Value: 20},
+	}
+
+	pq, err := NewFromItems(func(x, y int) bool {
+		return x < y
+	}, items, WithCapacity[string, int](16))
+	if err != nil {
+		t.Fatalf("NewFromItems(): got unexpected error %v", err)
+	}
+
+	if err := pq.CheckInvariant(); err != nil {
+		t.Errorf("pq.CheckInvariant(): got %v; want nil", err)
+	}
+	if got, want := pq.Cap(), 16; got != want {
+		t.Errorf("pq.Cap(): got %d; want %d", got, want)
+	}
+
+	for _, want := range []string{"first", "second", "third", "fourth", "last"} {
+		if k, _ := pq.MustPop(); k != want {
+			t.Errorf("pq.MustPop(): got key %q; want %q", k, want)
+		}
+	}
+}
+
+func TestNewFromItems_Errors(t *testing.T) {
+	cmp := func(x, y int) bool {
+		return x < y
+	}
+
+	t.Run("DuplicateKey", func(t *testing.T) {
+		items := []Item[string, int]{{Key: "first", Value: 1}, {Key: "second", Value: 2}, {Key: "first", Value: 3}}
+
+		_, err := NewFromItems(cmp, items)
+
+		var wantErr KeyAlreadyExistsError[string]
+		if !errors.As(err, &wantErr) {
+			t.Errorf("NewFromItems(): got error type %T; want it to be %T", err, wantErr)
+		}
+	})
+
+	t.Run("CapacityExceeded", func(t *testing.T) {
+		items := []Item[string, int]{{Key: "first", Value: 1}, {Key: "second", Value: 2}, {Key: "third", Value: 3}}
+
+		_, err := NewFromItems(cmp, items, WithMaxSize[string, int](2, false))
+
+		var wantErr CapacityExceededError[string]
+		if !errors.As(err, &wantErr) {
+			t.Errorf("NewFromItems(): got error type %T; want it to be %T", err, wantErr)
+		}
+	})
+}
+
 func TestKeyedPriorityQueue_Push(t *testing.T) {
 	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
 		return x < y
@@ -150,296 +270,3050 @@ func TestKeyedPriorityQueue_Update(t *testing.T) {
 	}
 }
 
-func TestKeyedPriorityQueue_Update_Error(t *testing.T) {
-	t.Run("KeyNotFound", func(t *testing.T) {
-		pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
-			return x < y
-		})
-
-		k := "key-not-found"
-		err := pq.Update(k, 20)
-
-		var wantErr KeyNotFoundError[string]
-		if !errors.As(err, &wantErr) {
-			t.Errorf("pq.Update(%q, 20): got error type %T; want it to be %T", k, err, wantErr)
-		}
+func TestKeyedPriorityQueue_Update_Random(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
 	})
-}
 
-func TestKeyedPriorityQueue_Pop(t *testing.T) {
-	t.Run("Keys", func(t *testing.T) {
-		pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
-			return x < y
-		})
+	r := rand.New(rand.NewSource(1))
+	n := 200
+	want := make([]int, n)
+	for k := 0; k < n; k++ {
+		want[k] = r.Intn(1000)
+		pq.Push(k, want[k])
+	}
 
-		items := []struct {
-			key string
-			val int
-		}{
-			{key: "fourth", val: 10},
-			{key: "second", val: 8},
-			{key: "third", val: 9},
-			{key: "first", val: 6},
-			{key: "last", val: 20},
+	for i := 0; i < 1000; i++ {
+		k, v := r.Intn(n), r.Intn(1000)
+		if err := pq.Update(k, v); err != nil {
+			t.Fatalf("pq.Update(%d, %d): got unexpected error: %v", k, v, err)
 		}
+		want[k] = v
 
-		for _, item := range items {
-			err := pq.Push(item.key, item.val)
-			if err != nil {
-				t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
-			}
+		if err := pq.CheckInvariant(); err != nil {
+			t.Fatalf("pq.CheckInvariant(): got unexpected error: %v", err)
 		}
+	}
 
-		testCases := []struct {
-			wantKey       string
-			wantValue     int
-			wantPeekKey   string
-			wantPeekValue int
-			wantLen       int
-		}{
-			{
-				wantKey:       "first",
-				wantValue:     6,
-				wantPeekKey:   "second",
-				wantPeekValue: 8,
-				wantLen:       4,
-			},
-			{
-				wantKey:       "second",
-				wantValue:     8,
-				wantPeekKey:   "third",
-				wantPeekValue: 9,
-				wantLen:       3,
-			},
+	sort.Ints(want)
+	for _, wantVal := range want {
+		_, got, ok := pq.Pop()
+		if !ok {
+			t.Fatal("pq.Pop(): got unexpected empty priority queue")
 		}
+		if got != wantVal {
+			t.Fatalf("pq.Pop(): got value %d; want %d", got, wantVal)
+		}
+	}
+}
 
-		for _, tc := range testCases {
-			t.Run(fmt.Sprintf("%s_%d", tc.wantKey, tc.wantValue), func(t *testing.T) {
-				gotKey, gotValue, ok := pq.Pop()
-				if !ok {
-					t.Fatal("pq.Pop(): got unexpected empty prioriy queue")
-				}
+func TestKeyedPriorityQueue_RandomOperations(t *testing.T) {
+	for seed := int64(0); seed < 200; seed++ {
+		pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+			return x < y
+		})
 
-				if gotKey != tc.wantKey {
-					t.Errorf("pq.Pop(): got key %q; want %q", gotKey, tc.wantKey)
+		r := rand.New(rand.NewSource(seed))
+		want := make(map[int]int)
+		next := 0
+		for i := 0; i < 500; i++ {
+			// pushes outnumber pops, so that the heap grows a few levels deep.
+			switch op := r.Intn(4); {
+			case op < 2 || len(want) == 0:
+				want[next] = r.Intn(1000)
+				if err := pq.Push(next, want[next]); err != nil {
+					t.Fatalf("seed %d: pq.Push(%d, %d): got unexpected error: %v", seed, next, want[next], err)
 				}
-
-				if gotValue != tc.wantValue {
-					t.Errorf("pq.Pop(): got value %d; want %d", gotValue, tc.wantValue)
+				next++
+			case op == 2:
+				minVal := -1
+				for _, v := range want {
+					if minVal < 0 || v < minVal {
+						minVal = v
+					}
 				}
-
-				gotPeekKey, gotPeekValue, ok := pq.Peek()
+				k, got, ok := pq.Pop()
 				if !ok {
-					t.Fatal("got no min key and value in the priority queue")
+					t.Fatalf("seed %d: pq.Pop(): got unexpected empty priority queue", seed)
 				}
-
-				if gotPeekKey != tc.wantPeekKey {
-					t.Errorf("pq.Peek(): got key %q; want %q", gotPeekKey, tc.wantPeekKey)
+				if got != minVal || want[k] != got {
+					t.Fatalf("seed %d: pq.Pop(): got (%d, %d); want value %d", seed, k, got, minVal)
 				}
-
-				if gotPeekValue != tc.wantPeekValue {
-					t.Errorf("pq.Peek(): got value %d; want %d", gotPeekValue, tc.wantPeekValue)
+				delete(want, k)
+			default:
+				k := r.Intn(next)
+				if _, ok := want[k]; !ok {
+					continue // k was popped already
 				}
-
-				if got := pq.Len(); got != tc.wantLen {
-					t.Errorf("pq.Len(): got %d; want %d", got, tc.wantLen)
+				want[k] = r.Intn(1000)
+				if err := pq.Update(k, want[k]); err != nil {
+					t.Fatalf("seed %d: pq.Update(%d, %d): got unexpected error: %v", seed, k, want[k], err)
 				}
-			})
+			}
 		}
-	})
+	}
+}
 
-	t.Run("EmptyPQ", func(t *testing.T) {
+func TestKeyedPriorityQueue_CheckInvariant(t *testing.T) {
+	newQueue := func() *KeyedPriorityQueue[string, int] {
 		pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
 			return x < y
 		})
-
-		_, _, ok := pq.Pop()
-		if ok {
-			t.Errorf("pq.Pop(): got unexpected non-empty priorit queue")
+		for i, k := range []string{"a", "b", "c", "d", "e"} {
+			pq.MustPush(k, i)
 		}
-	})
-}
-
-func TestKeyedPriorityQueue_Remove(t *testing.T) {
-	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
-		return x < y
-	})
-
-	items := []struct {
-		key string
-		val int
-	}{
-		{key: "fourth", val: 10},
-		{key: "second", val: 8},
-		{key: "third", val: 9},
-		{key: "first", val: 6},
-		{key: "last", val: 20},
+		return pq
 	}
 
-	for _, item := range items {
-		err := pq.Push(item.key, item.val)
-		if err != nil {
-			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
-		}
+	if err := newQueue().CheckInvariant(); err != nil {
+		t.Fatalf("pq.CheckInvariant(): got unexpected error: %v", err)
 	}
 
-	t.Run("Keys", func(t *testing.T) {
-		testCases := []struct {
-			key           string
-			wantPeekKey   string
-			wantPeekValue int
-			wantLen       int
-		}{
-			{
-				key:           "first",
-				wantPeekKey:   "second",
-				wantPeekValue: 8,
-				wantLen:       4,
+	testCases := []struct {
+		name    string
+		corrupt func(pq *KeyedPriorityQueue[string, int])
+	}{
+		{
+			name: "HeapOrder",
+			corrupt: func(pq *KeyedPriorityQueue[string, int]) {
+				pq.pv[4] = -1
 			},
-			{
-				key:           "third",
-				wantPeekKey:   "second",
-				wantPeekValue: 8,
-				wantLen:       3,
+		},
+		{
+			name: "Index",
+			corrupt: func(pq *KeyedPriorityQueue[string, int]) {
+				pq.pm[1], pq.pm[2] = pq.pm[2], pq.pm[1]
 			},
-			{
-				key:           "second",
-				wantPeekKey:   "fourth",
-				wantPeekValue: 10,
-				wantLen:       2,
+		},
+		{
+			name: "MissingIndex",
+			corrupt: func(pq *KeyedPriorityQueue[string, int]) {
+				delete(pq.im, "c")
 			},
-			{
-				key:           "last",
-				wantPeekKey:   "fourth",
-				wantPeekValue: 10,
-				wantLen:       1,
+		},
+		{
+			name: "MissingValue",
+			corrupt: func(pq *KeyedPriorityQueue[string, int]) {
+				pq.pv = pq.pv[:len(pq.pv)-1]
 			},
-		}
+		},
+		{
+			name: "Size",
+			corrupt: func(pq *KeyedPriorityQueue[string, int]) {
+				pq.size.Add(1)
+			},
+		},
+	}
 
-		for _, tc := range testCases {
-			t.Run(tc.key, func(t *testing.T) {
-				pq.Remove(tc.key)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := newQueue()
+			tc.corrupt(pq)
 
-				gotPeekKey, gotPeekValue, ok := pq.Peek()
-				if !ok {
-					t.Fatal("got no min key and value in the priority queue")
-				}
+			if err := pq.CheckInvariant(); err == nil {
+				t.Error("pq.CheckInvariant(): got nil error; want error")
+			}
+		})
+	}
+}
 
-				if gotPeekKey != tc.wantPeekKey {
-					t.Errorf("pq.Peek(): got key %q; want %q", gotPeekKey, tc.wantPeekKey)
-				}
+func TestKeyedPriorityQueue_Update_Error(t *testing.T) {
+	t.Run("KeyNotFound", func(t *testing.T) {
+		pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+			return x < y
+		})
 
-				if gotPeekValue != tc.wantPeekValue {
-					t.Errorf("pq.PeekValue(): got value %d; want %d", gotPeekValue, tc.wantPeekValue)
-				}
+		k := "key-not-found"
+		err := pq.Update(k, 20)
 
-				if got := pq.Len(); got != tc.wantLen {
-					t.Errorf("pq.Len(): got %d; want %d", got, tc.wantLen)
-				}
-			})
+		var wantErr KeyNotFoundError[string]
+		if !errors.As(err, &wantErr) {
+			t.Errorf("pq.Update(%q, 20): got error type %T; want it to be %T", k, err, wantErr)
 		}
 	})
+}
 
-	t.Run("NonExistingKey", func(t *testing.T) {
-		want := pq.Len()
-		pq.Remove("non-existing-key")
-
-		if got := pq.Len(); got != want {
+func TestKeyedPriorityQueue_UpdateIf(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	for _, k := range []string{"a", "b"} {
+		if err := pq.Push(k, 10); err != nil {
+			t.Fatalf("pq.Push(%q, 10): got unexpected error %v", k, err)
+		}
+	}
+
+	lower := func(old, new int) bool {
+		return new < old
+	}
+
+	testCases := []struct {
+		key         string
+		newValue    int
+		wantUpdated bool
+		wantValue   int
+	}{
+		{key: "b", newValue: 20, wantUpdated: false, wantValue: 10},
+		{key: "b", newValue: 5, wantUpdated: true, wantValue: 5},
+		{key: "b", newValue: 5, wantUpdated: false, wantValue: 5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s_%d", tc.key, tc.newValue), func(t *testing.T) {
+			updated, err := pq.UpdateIf(tc.key, tc.newValue, lower)
+			if err != nil {
+				t.Fatalf("pq.UpdateIf(%q, %d, lower): got unexpected error: %v", tc.key, tc.newValue, err)
+			}
+			if updated != tc.wantUpdated {
+				t.Errorf("pq.UpdateIf(%q, %d, lower): got updated %t; want %t", tc.key, tc.newValue, updated, tc.wantUpdated)
+			}
+			if got, _ := pq.ValueOf(tc.key); got != tc.wantValue {
+				t.Errorf("pq.ValueOf(%q): got %d; want %d", tc.key, got, tc.wantValue)
+			}
+		})
+	}
+
+	if got, _ := pq.PeekKey(); got != "b" {
+		t.Errorf("pq.PeekKey(): got %q; want %q", got, "b")
+	}
+
+	t.Run("KeyNotFound", func(t *testing.T) {
+		k := "key-not-found"
+		_, err := pq.UpdateIf(k, 1, lower)
+
+		var wantErr KeyNotFoundError[string]
+		if !errors.As(err, &wantErr) {
+			t.Errorf("pq.UpdateIf(%q, 1, lower): got error type %T; want it to be %T", k, err, wantErr)
+		}
+	})
+}
+
+func TestKeyedPriorityQueue_CompareAndUpdate(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	for _, k := range []string{"a", "b"} {
+		if err := pq.Push(k, 10); err != nil {
+			t.Fatalf("pq.Push(%q, 10): got unexpected error %v", k, err)
+		}
+	}
+
+	eq := func(a, b int) bool {
+		return a == b
+	}
+
+	testCases := []struct {
+		key         string
+		expected    int
+		newValue    int
+		wantSwapped bool
+		wantValue   int
+	}{
+		{key: "b", expected: 20, newValue: 5, wantSwapped: false, wantValue: 10},
+		{key: "b", expected: 10, newValue: 5, wantSwapped: true, wantValue: 5},
+		{key: "b", expected: 10, newValue: 1, wantSwapped: false, wantValue: 5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s_%d_%d", tc.key, tc.expected, tc.newValue), func(t *testing.T) {
+			swapped, err := pq.CompareAndUpdate(tc.key, tc.expected, tc.newValue, eq)
+			if err != nil {
+				t.Fatalf("pq.CompareAndUpdate(%q, %d, %d, eq): got unexpected error: %v", tc.key, tc.expected, tc.newValue, err)
+			}
+			if swapped != tc.wantSwapped {
+				t.Errorf("pq.CompareAndUpdate(%q, %d, %d, eq): got swapped %t; want %t", tc.key, tc.expected, tc.newValue, swapped, tc.wantSwapped)
+			}
+			if got, _ := pq.ValueOf(tc.key); got != tc.wantValue {
+				t.Errorf("pq.ValueOf(%q): got %d; want %d", tc.key, got, tc.wantValue)
+			}
+		})
+	}
+
+	if got, _ := pq.PeekKey(); got != "b" {
+		t.Errorf("pq.PeekKey(): got %q; want %q", got, "b")
+	}
+
+	t.Run("KeyNotFound", func(t *testing.T) {
+		k := "key-not-found"
+		_, err := pq.CompareAndUpdate(k, 1, 2, eq)
+
+		var wantErr KeyNotFoundError[string]
+		if !errors.As(err, &wantErr) {
+			t.Errorf("pq.CompareAndUpdate(%q, 1, 2, eq): got error type %T; want it to be %T", k, err, wantErr)
+		}
+	})
+}
+
+func TestKeyedPriorityQueue_Touch(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	pq.MustPush("first", 1)
+	pq.MustPush("second", 2)
+	pq.MustPush("third", 3)
+
+	now := 10
+	var calls int
+	clock := func() int {
+		calls++
+		now++
+		return now
+	}
+
+	err := pq.Touch("missing", clock)
+	var wantErr KeyNotFoundError[string]
+	if !errors.As(err, &wantErr) {
+		t.Errorf("pq.Touch(%q, clock): got error type %T; want it to be %T", "missing", err, wantErr)
+	}
+	if calls != 0 {
+		t.Errorf("pq.Touch(%q, clock): got %d calls of fn; want 0", "missing", calls)
+	}
+
+	for _, k := range []string{"first", "third"} {
+		if err := pq.Touch(k, clock); err != nil {
+			t.Errorf("pq.Touch(%q, clock): got unexpected error %v", k, err)
+		}
+	}
+
+	for _, want := range []string{"second", "first", "third"} {
+		if k, _ := pq.MustPop(); k != want {
+			t.Errorf("pq.MustPop(): got key %q; want %q", k, want)
+		}
+	}
+}
+
+func TestKeyedPriorityQueue_Fix(t *testing.T) {
+	type task struct {
+		prio int
+	}
+	pq := NewKeyedPriorityQueue[string](func(x, y *task) bool {
+		return x.prio < y.prio
+	})
+
+	tasks := map[string]*task{
+		"a": {prio: 10},
+		"b": {prio: 20},
+		"c": {prio: 30},
+		"d": {prio: 40},
+	}
+	for k, v := range tasks {
+		pq.MustPush(k, v)
+	}
+
+	err := pq.Fix("missing")
+	var wantErr KeyNotFoundError[string]
+	if !errors.As(err, &wantErr) {
+		t.Errorf("pq.Fix(%q): got error type %T; want it to be %T", "missing", err, wantErr)
+	}
+
+	testCases := []struct {
+		key     string
+		prio    int
+		wantTop string
+	}{
+		{key: "d", prio: 5, wantTop: "d"},
+		{key: "d", prio: 50, wantTop: "a"},
+		{key: "a", prio: 25, wantTop: "b"},
+	}
+
+	for _, tc := range testCases {
+		tasks[tc.key].prio = tc.prio
+		if err := pq.Fix(tc.key); err != nil {
+			t.Fatalf("pq.Fix(%q): got unexpected error %v", tc.key, err)
+		}
+		if k, _, _ := pq.Peek(); k != tc.wantTop {
+			t.Errorf("pq.Fix(%q) with priority %d: got top key %q; want %q", tc.key, tc.prio, k, tc.wantTop)
+		}
+		if err := pq.CheckInvariant(); err != nil {
+			t.Errorf("pq.Fix(%q): got invariant violation %v", tc.key, err)
+		}
+	}
+}
+
+func TestKeyedPriorityQueue_Reheapify(t *testing.T) {
+	type task struct {
+		prio int
+	}
+	pq := NewKeyedPriorityQueue[int](func(x, y *task) bool {
+		return x.prio < y.prio
+	})
+
+	r := rand.New(rand.NewSource(1))
+	tasks := make(map[int]*task)
+	for k := 0; k < 100; k++ {
+		tasks[k] = &task{prio: r.Intn(1000)}
+		pq.MustPush(k, tasks[k])
+	}
+
+	for _, v := range tasks {
+		v.prio = r.Intn(1000)
+	}
+	pq.Reheapify()
+
+	if err := pq.CheckInvariant(); err != nil {
+		t.Fatalf("pq.Reheapify(): got invariant violation %v", err)
+	}
+
+	prev := -1
+	for !pq.IsEmpty() {
+		_, v := pq.MustPop()
+		if v.prio < prev {
+			t.Fatalf("pq.MustPop(): got priority %d after %d; want them in order", v.prio, prev)
+		}
+		prev = v.prio
+	}
+}
+
+func TestKeyedPriorityQueue_ReplaceValues(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithLazyDeletion[string, int](1))
+
+	for k, v := range map[string]int{"first": 1, "second": 2, "third": 3, "removed": 4} {
+		pq.MustPush(k, v)
+	}
+	pq.Remove("removed")
+
+	var missing MissingValueError[string]
+	err := pq.ReplaceValues(map[string]int{"first": 30, "second": 20})
+	if !errors.As(err, &missing) || missing.Key() != "third" {
+		t.Errorf("pq.ReplaceValues(vals): got error %v; want a %T for key %q", err, missing, "third")
+	}
+
+	var notFound KeyNotFoundError[string]
+	err = pq.ReplaceValues(map[string]int{"first": 30, "second": 20, "third": 10, "removed": 0})
+	if !errors.As(err, &notFound) || notFound.Key() != "removed" {
+		t.Errorf("pq.ReplaceValues(vals): got error %v; want a %T for key %q", err, notFound, "removed")
+	}
+
+	if k, _, _ := pq.Peek(); k != "first" {
+		t.Errorf("pq.Peek(): got key %q after failed replacements; want %q", k, "first")
+	}
+
+	if err := pq.ReplaceValues(map[string]int{"first": 30, "second": 20, "third": 10}); err != nil {
+		t.Fatalf("pq.ReplaceValues(vals): got unexpected error %v", err)
+	}
+	if err := pq.CheckInvariant(); err != nil {
+		t.Errorf("pq.CheckInvariant(): got unexpected error %v", err)
+	}
+
+	for _, want := range []Item[string, int]{{Key: "third", Value: 10}, {Key: "second", Value: 20}, {Key: "first", Value: 30}} {
+		if k, v := pq.MustPop(); k != want.Key || v != want.Value {
+			t.Errorf("pq.MustPop(): got (%q, %d); want (%q, %d)", k, v, want.Key, want.Value)
+		}
+	}
+}
+
+func TestKeyedPriorityQueue_Repair(t *testing.T) {
+	type task struct {
+		prio int
+	}
+	pq := NewKeyedPriorityQueue[int](func(x, y *task) bool {
+		return x.prio < y.prio
+	})
+
+	r := rand.New(rand.NewSource(1))
+	tasks := make([]*task, 50)
+	for k := range tasks {
+		tasks[k] = &task{prio: r.Intn(1000)}
+		pq.MustPush(k, tasks[k])
+	}
+
+	if got := pq.Repair(); got != 0 {
+		t.Errorf("pq.Repair(): got %d changed positions on a consistent priority queue; want 0", got)
+	}
+
+	for _, v := range tasks {
+		v.prio = r.Intn(1000)
+	}
+	// simulates an index drift, swapping the positions of two keys.
+	a, b := pq.pm[1], pq.pm[2]
+	pq.im[a], pq.im[b] = 2, 1
+
+	if err := pq.CheckInvariant(); err == nil {
+		t.Fatal("pq.CheckInvariant(): got nil on a corrupted priority queue; want an error")
+	}
+	if got := pq.Repair(); got < 2 {
+		t.Errorf("pq.Repair(): got %d changed positions; want at least 2", got)
+	}
+	if err := pq.CheckInvariant(); err != nil {
+		t.Fatalf("pq.CheckInvariant(): got unexpected error %v after repairing", err)
+	}
+
+	prev := -1
+	for !pq.IsEmpty() {
+		_, v := pq.MustPop()
+		if v.prio < prev {
+			t.Fatalf("pq.MustPop(): got priority %d after %d; want them in order", v.prio, prev)
+		}
+		prev = v.prio
+	}
+}
+
+func TestKeyedPriorityQueue_Adjust(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	for k, v := range map[string]int{"a": 10, "b": 20, "c": 30} {
+		if err := pq.Push(k, v); err != nil {
+			t.Fatalf("pq.Push(%q, %d): got unexpected error %v", k, v, err)
+		}
+	}
+
+	add := func(old, delta int) int {
+		return old + delta
+	}
+
+	testCases := []struct {
+		key       string
+		delta     int
+		wantValue int
+		wantTop   string
+	}{
+		{key: "c", delta: -25, wantValue: 5, wantTop: "c"},
+		{key: "c", delta: 10, wantValue: 15, wantTop: "a"},
+		{key: "a", delta: 10, wantValue: 20, wantTop: "c"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s_%d", tc.key, tc.delta), func(t *testing.T) {
+			if err := pq.Adjust(tc.key, tc.delta, add); err != nil {
+				t.Fatalf("pq.Adjust(%q, %d, add): got unexpected error: %v", tc.key, tc.delta, err)
+			}
+			if got, _ := pq.ValueOf(tc.key); got != tc.wantValue {
+				t.Errorf("pq.ValueOf(%q): got %d; want %d", tc.key, got, tc.wantValue)
+			}
+			if got, _ := pq.PeekKey(); got != tc.wantTop {
+				t.Errorf("pq.PeekKey(): got %q; want %q", got, tc.wantTop)
+			}
+		})
+	}
+
+	t.Run("KeyNotFound", func(t *testing.T) {
+		k := "key-not-found"
+		err := pq.Adjust(k, 1, add)
+
+		var wantErr KeyNotFoundError[string]
+		if !errors.As(err, &wantErr) {
+			t.Errorf("pq.Adjust(%q, 1, add): got error type %T; want it to be %T", k, err, wantErr)
+		}
+	})
+}
+
+func TestKeyedPriorityQueue_RenameKey(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	wantIndex, _ := pq.IndexOf("first")
+	if err := pq.RenameKey("first", "1st"); err != nil {
+		t.Fatalf("pq.RenameKey(%q, %q): got unexpected error %v", "first", "1st", err)
+	}
+
+	if pq.Contains("first") {
+		t.Errorf("pq.Contains(%q): got unexpected renamed key in priority queue", "first")
+	}
+	if got, _ := pq.ValueOf("1st"); got != 6 {
+		t.Errorf("pq.ValueOf(%q): got %d; want %d", "1st", got, 6)
+	}
+	if got, _ := pq.IndexOf("1st"); got != wantIndex {
+		t.Errorf("pq.IndexOf(%q): got %d; want %d", "1st", got, wantIndex)
+	}
+	if got, _ := pq.PeekKey(); got != "1st" {
+		t.Errorf("pq.PeekKey(): got %q; want %q", got, "1st")
+	}
+
+	if err := pq.RenameKey("1st", "1st"); err != nil {
+		t.Errorf("pq.RenameKey(%q, %q): got unexpected error %v", "1st", "1st", err)
+	}
+
+	t.Run("KeyNotFound", func(t *testing.T) {
+		err := pq.RenameKey("first", "new")
+
+		var wantErr KeyNotFoundError[string]
+		if !errors.As(err, &wantErr) {
+			t.Errorf("pq.RenameKey(%q, %q): got error type %T; want it to be %T", "first", "new", err, wantErr)
+		}
+	})
+
+	t.Run("KeyAlreadyExists", func(t *testing.T) {
+		err := pq.RenameKey("second", "third")
+
+		var wantErr KeyAlreadyExistsError[string]
+		if !errors.As(err, &wantErr) {
+			t.Errorf("pq.RenameKey(%q, %q): got error type %T; want it to be %T", "second", "third", err, wantErr)
+		}
+	})
+}
+
+func TestKeyedPriorityQueue_SwapPriorities(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	if err := pq.SwapPriorities("first", "last"); err != nil {
+		t.Fatalf("pq.SwapPriorities(%q, %q): got unexpected error %v", "first", "last", err)
+	}
+	if err := pq.SwapPriorities("second", "second"); err != nil {
+		t.Fatalf("pq.SwapPriorities(%q, %q): got unexpected error %v", "second", "second", err)
+	}
+
+	wantKeys := []string{"last", "second", "third", "fourth", "first"}
+	for _, want := range wantKeys {
+		got, _, ok := pq.Pop()
+		if !ok {
+			t.Fatal("pq.Pop(): got unexpected empty priority queue")
+		}
+		if got != want {
+			t.Errorf("pq.Pop(): got key %q; want %q", got, want)
+		}
+	}
+
+	t.Run("KeyNotFound", func(t *testing.T) {
+		pq.Push("key", 1)
+		err := pq.SwapPriorities("key", "key-not-found")
+
+		var wantErr KeyNotFoundError[string]
+		if !errors.As(err, &wantErr) {
+			t.Errorf("pq.SwapPriorities(%q, %q): got error type %T; want it to be %T", "key", "key-not-found", err, wantErr)
+		}
+		if got := wantErr.Key(); got != "key-not-found" {
+			t.Errorf("err.Key(): got %q; want %q", got, "key-not-found")
+		}
+	})
+}
+
+func TestKeyedPriorityQueue_DecreaseKey(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	t.Run("Keys", func(t *testing.T) {
+		testCases := []struct {
+			key         string
+			newValue    int
+			wantPeekKey string
+		}{
+			{key: "last", newValue: 7, wantPeekKey: "first"},
+			{key: "third", newValue: 9, wantPeekKey: "first"},
+			{key: "fourth", newValue: 1, wantPeekKey: "fourth"},
+		}
+
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s_%d", tc.key, tc.newValue), func(t *testing.T) {
+				if err := pq.DecreaseKey(tc.key, tc.newValue); err != nil {
+					t.Fatalf("pq.DecreaseKey(%q, %d): got unexpected error: %v", tc.key, tc.newValue, err)
+				}
+
+				if got, _ := pq.PeekKey(); got != tc.wantPeekKey {
+					t.Errorf("pq.PeekKey(): got %q; want %q", got, tc.wantPeekKey)
+				}
+			})
+		}
+	})
+
+	t.Run("KeyNotFound", func(t *testing.T) {
+		k := "key-not-found"
+		err := pq.DecreaseKey(k, 1)
+
+		var wantErr KeyNotFoundError[string]
+		if !errors.As(err, &wantErr) {
+			t.Errorf("pq.DecreaseKey(%q, 1): got error type %T; want it to be %T", k, err, wantErr)
+		}
+	})
+
+	t.Run("LowerPriority", func(t *testing.T) {
+		defer func() {
+			if err := recover(); err == nil {
+				t.Error("want DecreaseKey to panic when receiving a lower priority value")
+			}
+		}()
+
+		pq.DecreaseKey("second", 30)
+	})
+}
+
+func TestKeyedPriorityQueue_IncreaseKey(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	t.Run("Keys", func(t *testing.T) {
+		testCases := []struct {
+			key         string
+			newValue    int
+			wantPeekKey string
+		}{
+			{key: "first", newValue: 6, wantPeekKey: "first"},
+			{key: "first", newValue: 30, wantPeekKey: "second"},
+			{key: "second", newValue: 11, wantPeekKey: "third"},
+		}
+
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s_%d", tc.key, tc.newValue), func(t *testing.T) {
+				if err := pq.IncreaseKey(tc.key, tc.newValue); err != nil {
+					t.Fatalf("pq.IncreaseKey(%q, %d): got unexpected error: %v", tc.key, tc.newValue, err)
+				}
+
+				if got, _ := pq.PeekKey(); got != tc.wantPeekKey {
+					t.Errorf("pq.PeekKey(): got %q; want %q", got, tc.wantPeekKey)
+				}
+			})
+		}
+	})
+
+	t.Run("KeyNotFound", func(t *testing.T) {
+		k := "key-not-found"
+		err := pq.IncreaseKey(k, 1)
+
+		var wantErr KeyNotFoundError[string]
+		if !errors.As(err, &wantErr) {
+			t.Errorf("pq.IncreaseKey(%q, 1): got error type %T; want it to be %T", k, err, wantErr)
+		}
+	})
+
+	t.Run("HigherPriority", func(t *testing.T) {
+		defer func() {
+			if err := recover(); err == nil {
+				t.Error("want IncreaseKey to panic when receiving a higher priority value")
+			}
+		}()
+
+		pq.IncreaseKey("third", 1)
+	})
+}
+
+func TestKeyedPriorityQueue_Pop(t *testing.T) {
+	t.Run("Keys", func(t *testing.T) {
+		pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+			return x < y
+		})
+
+		items := []struct {
+			key string
+			val int
+		}{
+			{key: "fourth", val: 10},
+			{key: "second", val: 8},
+			{key: "third", val: 9},
+			{key: "first", val: 6},
+			{key: "last", val: 20},
+		}
+
+		for _, item := range items {
+			err := pq.Push(item.key, item.val)
+			if err != nil {
+				t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+			}
+		}
+
+		testCases := []struct {
+			wantKey       string
+			wantValue     int
+			wantPeekKey   string
+			wantPeekValue int
+			wantLen       int
+		}{
+			{
+				wantKey:       "first",
+				wantValue:     6,
+				wantPeekKey:   "second",
+				wantPeekValue: 8,
+				wantLen:       4,
+			},
+			{
+				wantKey:       "second",
+				wantValue:     8,
+				wantPeekKey:   "third",
+				wantPeekValue: 9,
+				wantLen:       3,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(fmt.Sprintf("%s_%d", tc.wantKey, tc.wantValue), func(t *testing.T) {
+				gotKey, gotValue, ok := pq.Pop()
+				if !ok {
+					t.Fatal("pq.Pop(): got unexpected empty prioriy queue")
+				}
+
+				if gotKey != tc.wantKey {
+					t.Errorf("pq.Pop(): got key %q; want %q", gotKey, tc.wantKey)
+				}
+
+				if gotValue != tc.wantValue {
+					t.Errorf("pq.Pop(): got value %d; want %d", gotValue, tc.wantValue)
+				}
+
+				gotPeekKey, gotPeekValue, ok := pq.Peek()
+				if !ok {
+					t.Fatal("got no min key and value in the priority queue")
+				}
+
+				if gotPeekKey != tc.wantPeekKey {
+					t.Errorf("pq.Peek(): got key %q; want %q", gotPeekKey, tc.wantPeekKey)
+				}
+
+				if gotPeekValue != tc.wantPeekValue {
+					t.Errorf("pq.Peek(): got value %d; want %d", gotPeekValue, tc.wantPeekValue)
+				}
+
+				if got := pq.Len(); got != tc.wantLen {
+					t.Errorf("pq.Len(): got %d; want %d", got, tc.wantLen)
+				}
+			})
+		}
+	})
+
+	t.Run("EmptyPQ", func(t *testing.T) {
+		pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+			return x < y
+		})
+
+		_, _, ok := pq.Pop()
+		if ok {
+			t.Errorf("pq.Pop(): got unexpected non-empty priorit queue")
+		}
+	})
+}
+
+func TestKeyedPriorityQueue_MustPush(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	pq.MustPush("key", 10)
+	if !pq.Contains("key") {
+		t.Fatalf("pq.Contains(%q): got no key in priority queue", "key")
+	}
+
+	defer func() {
+		err, _ := recover().(error)
+
+		var wantErr KeyAlreadyExistsError[string]
+		if !errors.As(err, &wantErr) {
+			t.Errorf("pq.MustPush(%q, 20): got panic value of type %T; want it to be %T", "key", err, wantErr)
+		}
+	}()
+
+	pq.MustPush("key", 20)
+}
+
+func TestKeyedPriorityQueue_MustPop(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+	pq.MustPush("key", 10)
+
+	if k, v := pq.MustPop(); k != "key" || v != 10 {
+		t.Errorf("pq.MustPop(): got (%q, %d); want (%q, %d)", k, v, "key", 10)
+	}
+
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("want MustPop to panic on an empty priority queue")
+		}
+	}()
+
+	pq.MustPop()
+}
+
+func TestKeyedPriorityQueue_DrainParallel(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool { return x < y })
+
+	n := 1000
+	for k := 0; k < n; k++ {
+		pq.MustPush(k, n-k)
+	}
+
+	var mu sync.Mutex
+	seen := make(map[int]int, n)
+	pq.DrainParallel(4, func(k, v int) {
+		mu.Lock()
+		defer mu.Unlock()
+		seen[k] = v
+	})
+
+	if !pq.IsEmpty() {
+		t.Errorf("pq.IsEmpty(): got %d entries left; want empty priority queue", pq.Len())
+	}
+	if got := len(seen); got != n {
+		t.Fatalf("pq.DrainParallel(): got %d entries processed; want %d", got, n)
+	}
+	for k, v := range seen {
+		if v != n-k {
+			t.Errorf("pq.DrainParallel(): got value %d for key %d; want %d", v, k, n-k)
+		}
+	}
+}
+
+func TestKeyedPriorityQueue_DrainParallel_BusyWorkers(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool { return x < y })
+
+	n := 10
+	for k := 0; k < n; k++ {
+		pq.MustPush(k, k)
+	}
+
+	started := make(chan int)
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pq.DrainParallel(2, func(k, v int) {
+			started <- k
+			<-release
+		})
+	}()
+
+	busy := map[int]bool{<-started: true, <-started: true}
+	if !busy[0] || !busy[1] {
+		t.Errorf("pq.DrainParallel(): got keys %v processed first; want keys 0 and 1", busy)
+	}
+	if got, want := pq.Len(), n-2; got != want {
+		t.Errorf("pq.Len(): got %d while all workers are busy; want %d", got, want)
+	}
+
+	go func() {
+		for range started {
+		}
+	}()
+	close(release)
+	<-done
+	close(started)
+
+	if !pq.IsEmpty() {
+		t.Errorf("pq.IsEmpty(): got %d entries left; want empty priority queue", pq.Len())
+	}
+}
+
+func TestKeyedPriorityQueue_DrainParallel_InvalidWorkers(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool { return x < y })
+
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("want DrainParallel(0, fn) to panic")
+		}
+	}()
+
+	pq.DrainParallel(0, func(k, v int) {})
+}
+
+func TestKeyedPriorityQueue_PopIfTop(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	if _, ok := pq.PopIfTop("first"); ok {
+		t.Errorf("pq.PopIfTop(%q): got true on empty priority queue; want false", "first")
+	}
+
+	pq.MustPush("second", 20)
+	pq.MustPush("first", 10)
+
+	if _, ok := pq.PopIfTop("second"); ok {
+		t.Errorf("pq.PopIfTop(%q): got true; want false", "second")
+	}
+	if got, want := pq.Len(), 2; got != want {
+		t.Errorf("pq.Len(): got %d; want unchanged %d", got, want)
+	}
+
+	if v, ok := pq.PopIfTop("first"); !ok || v != 10 {
+		t.Errorf("pq.PopIfTop(%q): got (%d, %t); want (%d, %t)", "first", v, ok, 10, true)
+	}
+	if k, _ := pq.PeekKey(); k != "second" {
+		t.Errorf("pq.PeekKey(): got %q; want %q", k, "second")
+	}
+}
+
+func TestKeyedPriorityQueue_ConsumeTop(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	var seen []string
+	consumeBelow := func(limit int) func(k string, v int) bool {
+		return func(k string, v int) bool {
+			seen = append(seen, k)
+			return v < limit
+		}
+	}
+
+	if pq.ConsumeTop(consumeBelow(100)) {
+		t.Errorf("pq.ConsumeTop(fn): got true on empty priority queue; want false")
+	}
+	if len(seen) != 0 {
+		t.Errorf("pq.ConsumeTop(fn): got fn called with %v on empty priority queue; want no calls", seen)
+	}
+
+	pq.MustPush("first", 10)
+	pq.MustPush("second", 20)
+
+	if !pq.ConsumeTop(consumeBelow(15)) {
+		t.Errorf("pq.ConsumeTop(fn): got false; want true")
+	}
+	if pq.ConsumeTop(consumeBelow(15)) {
+		t.Errorf("pq.ConsumeTop(fn): got true for a declined entry; want false")
+	}
+
+	want := []string{"first", "second"}
+	if len(seen) != len(want) || seen[0] != want[0] || seen[1] != want[1] {
+		t.Errorf("pq.ConsumeTop(fn): got fn called with %v; want %v", seen, want)
+	}
+	if k, _, _ := pq.Peek(); k != "second" || pq.Len() != 1 {
+		t.Errorf("pq.Peek(): got key %q with size %d; want %q with size %d", k, pq.Len(), "second", 1)
+	}
+}
+
+func TestKeyedPriorityQueue_Reschedule(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	if _, ok := pq.Reschedule(1); ok {
+		t.Errorf("pq.Reschedule(%d): got true on empty priority queue; want false", 1)
+	}
+
+	pq.MustPush("first", 10)
+	pq.MustPush("second", 20)
+	pq.MustPush("third", 30)
+
+	if k, ok := pq.Reschedule(25); !ok || k != "first" {
+		t.Errorf("pq.Reschedule(%d): got (%q, %t); want (%q, %t)", 25, k, ok, "first", true)
+	}
+	if got, want := pq.Len(), 3; got != want {
+		t.Errorf("pq.Len(): got %d; want %d", got, want)
+	}
+	if v, _ := pq.ValueOf("first"); v != 25 {
+		t.Errorf("pq.ValueOf(%q): got %d; want %d", "first", v, 25)
+	}
+
+	want := []string{"second", "first", "third"}
+	for _, wk := range want {
+		k, _ := pq.MustPop()
+		if k != wk {
+			t.Errorf("pq.MustPop(): got key %q; want %q", k, wk)
+		}
+	}
+}
+
+func TestKeyedPriorityQueue_Reschedule_RoundRobin(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y }, WithStableOrdering[string, int]())
+
+	keys := []string{"a", "b", "c"}
+	for _, k := range keys {
+		pq.MustPush(k, 1)
+	}
+
+	for round := 0; round < 2; round++ {
+		for _, wk := range keys {
+			k, ok := pq.Reschedule(1)
+			if !ok || k != wk {
+				t.Errorf("pq.Reschedule(%d) in round %d: got (%q, %t); want (%q, %t)", 1, round, k, ok, wk, true)
+			}
+		}
+	}
+}
+
+func TestKeyedPriorityQueue_DeferTop(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	var calls int
+	backoff := func(k string, v int) int {
+		calls++
+		return v * 4
+	}
+
+	if _, ok := pq.DeferTop(backoff); ok {
+		t.Errorf("pq.DeferTop(backoff): got true on empty priority queue; want false")
+	}
+	if calls != 0 {
+		t.Errorf("pq.DeferTop(backoff): got %d calls of fn on empty priority queue; want 0", calls)
+	}
+
+	pq.MustPush("first", 10)
+	pq.MustPush("second", 20)
+	pq.MustPush("third", 30)
+
+	if k, ok := pq.DeferTop(backoff); !ok || k != "first" {
+		t.Errorf("pq.DeferTop(backoff): got (%q, %t); want (%q, %t)", k, ok, "first", true)
+	}
+	if v, _ := pq.ValueOf("first"); v != 40 {
+		t.Errorf("pq.ValueOf(%q): got %d; want %d", "first", v, 40)
+	}
+	if got, want := pq.Len(), 3; got != want {
+		t.Errorf("pq.Len(): got %d; want %d", got, want)
+	}
+
+	want := []string{"second", "third", "first"}
+	for _, wk := range want {
+		k, _ := pq.MustPop()
+		if k != wk {
+			t.Errorf("pq.MustPop(): got key %q; want %q", k, wk)
+		}
+	}
+}
+
+func TestKeyedPriorityQueue_PopLess(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	if got := pq.PopLess(10); got != nil {
+		t.Errorf("pq.PopLess(10): got %v on empty priority queue; want nil", got)
+	}
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	if got := pq.PopLess(6); got != nil {
+		t.Errorf("pq.PopLess(6): got %v; want nil, as the threshold is exclusive", got)
+	}
+
+	got := pq.PopLess(10)
+	want := []Item[string, int]{{Key: "first", Value: 6}, {Key: "second", Value: 8}, {Key: "third", Value: 9}}
+	if len(got) != len(want) {
+		t.Fatalf("pq.PopLess(10): got %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("pq.PopLess(10)[%d]: got %v; want %v", i, got[i], want[i])
+		}
+	}
+
+	if k, _, _ := pq.Peek(); k != "fourth" {
+		t.Errorf("pq.Peek(): got key %q; want %q", k, "fourth")
+	}
+}
+
+func TestKeyedPriorityQueue_PopFunc(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithLazyDeletion[string, int](1))
+
+	even := func(k string, v int) bool {
+		return v%2 == 0
+	}
+
+	if got, ok := pq.PopFunc(even); ok {
+		t.Errorf("pq.PopFunc(even): got (%v, %t) on empty priority queue; want false", got, ok)
+	}
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 7},
+		{key: "third", val: 9},
+		{key: "first", val: 5},
+		{key: "last", val: 20},
+		{key: "removed", val: 6},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+	pq.Remove("removed")
+
+	for _, want := range []Item[string, int]{{Key: "fourth", Value: 10}, {Key: "last", Value: 20}} {
+		if got, ok := pq.PopFunc(even); !ok || got != want {
+			t.Errorf("pq.PopFunc(even): got (%v, %t); want (%v, %t)", got, ok, want, true)
+		}
+		if err := pq.CheckInvariant(); err != nil {
+			t.Errorf("pq.CheckInvariant(): got %v; want nil", err)
+		}
+	}
+
+	if got, ok := pq.PopFunc(even); ok {
+		t.Errorf("pq.PopFunc(even): got (%v, %t) with no matching entry; want false", got, ok)
+	}
+
+	for _, want := range []string{"first", "second", "third"} {
+		if k, _ := pq.MustPop(); k != want {
+			t.Errorf("pq.MustPop(): got key %q; want %q", k, want)
+		}
+	}
+}
+
+func TestKeyedPriorityQueue_PopUntil(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	due := func(k string, v int) bool {
+		return v <= 9
+	}
+
+	got := pq.PopUntil(due)
+	want := []Item[string, int]{{Key: "first", Value: 6}, {Key: "second", Value: 8}, {Key: "third", Value: 9}}
+	if len(got) != len(want) {
+		t.Fatalf("pq.PopUntil(due): got %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("pq.PopUntil(due)[%d]: got %v; want %v", i, got[i], want[i])
+		}
+	}
+
+	if got, want := pq.Len(), 2; got != want {
+		t.Errorf("pq.Len(): got %d; want %d", got, want)
+	}
+
+	if got := pq.PopUntil(due); got != nil {
+		t.Errorf("pq.PopUntil(due): got %v; want nil", got)
+	}
+}
+
+func TestKeyedPriorityQueue_DrainToChannel(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	ch := make(chan Item[string, int])
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pq.DrainToChannel(ch)
+	}()
+
+	for i, want := range []string{"first", "second", "third", "fourth", "last"} {
+		got := <-ch
+		if got.Key != want {
+			t.Errorf("pq.DrainToChannel(ch): got key %q; want %q", got.Key, want)
+		}
+		if i == 0 {
+			// entries pushed while draining aren't counted in.
+			pq.Push("late", 100)
+		}
+	}
+	<-done
+
+	select {
+	case item := <-ch:
+		t.Errorf("pq.DrainToChannel(ch): got unexpected item %v", item)
+	default:
+	}
+	if k, _, ok := pq.Peek(); !ok || k != "late" {
+		t.Errorf("pq.Peek(): got (%q, %t); want (%q, %t)", k, ok, "late", true)
+	}
+}
+
+func TestKeyedPriorityQueue_PopInto(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+	pq.MustPush("second", 20)
+	pq.MustPush("first", 10)
+
+	var k string
+	var v int
+	if ok := pq.PopInto(&k, &v); !ok || k != "first" || v != 10 {
+		t.Errorf("pq.PopInto(&k, &v): got (%q, %d, %t); want (%q, %d, %t)", k, v, ok, "first", 10, true)
+	}
+
+	if ok := pq.PopInto(&k, nil); !ok || k != "second" {
+		t.Errorf("pq.PopInto(&k, nil): got (%q, %t); want (%q, %t)", k, ok, "second", true)
+	}
+
+	if ok := pq.PopInto(&k, &v); ok || k != "second" || v != 10 {
+		t.Errorf("pq.PopInto(&k, &v): got (%q, %d, %t); want unchanged (%q, %d, %t)", k, v, ok, "second", 10, false)
+	}
+}
+
+func TestKeyedPriorityQueue_Poll(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+	pq.MustPush("second", 20)
+	pq.MustPush("first", 10)
+
+	for _, want := range []Item[string, int]{{Key: "first", Value: 10}, {Key: "second", Value: 20}} {
+		if got, ok := pq.Poll(); !ok || got != want {
+			t.Errorf("pq.Poll(): got (%v, %t); want (%v, %t)", got, ok, want, true)
+		}
+	}
+
+	if got, ok := pq.Poll(); ok || got != (Item[string, int]{}) {
+		t.Errorf("pq.Poll(): got (%v, %t); want zero Item and false", got, ok)
+	}
+}
+
+func TestKeyedPriorityQueue_PushTracked(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	}, WithCapacity[int, int](2))
+
+	testCases := []struct {
+		key      int
+		wantGrew bool
+	}{
+		{key: 1, wantGrew: false},
+		{key: 2, wantGrew: false},
+		{key: 3, wantGrew: true},
+	}
+
+	for _, tc := range testCases {
+		grew, err := pq.PushTracked(tc.key, tc.key)
+		if err != nil {
+			t.Fatalf("pq.PushTracked(%d, %d): got unexpected error %v", tc.key, tc.key, err)
+		}
+		if grew != tc.wantGrew {
+			t.Errorf("pq.PushTracked(%d, %d): got grew %t; want %t", tc.key, tc.key, grew, tc.wantGrew)
+		}
+	}
+
+	grew, err := pq.PushTracked(1, 1)
+	var wantErr KeyAlreadyExistsError[int]
+	if !errors.As(err, &wantErr) {
+		t.Errorf("pq.PushTracked(1, 1): got error type %T; want it to be %T", err, wantErr)
+	}
+	if grew {
+		t.Errorf("pq.PushTracked(1, 1): got grew true for an existing key; want false")
+	}
+}
+
+func TestKeyedPriorityQueue_Offer(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	if ok := pq.Offer("key", 10); !ok {
+		t.Errorf("pq.Offer(%q, 10): got false; want true", "key")
+	}
+
+	if ok := pq.Offer("key", 5); ok {
+		t.Errorf("pq.Offer(%q, 5): got true; want false", "key")
+	}
+
+	if v, _ := pq.ValueOf("key"); v != 10 {
+		t.Errorf("pq.ValueOf(%q): got %d; want unchanged %d", "key", v, 10)
+	}
+
+	testCases := []struct {
+		name  string
+		evict bool
+	}{
+		{name: "WithEviction", evict: true},
+		{name: "WithoutEviction", evict: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+				return x < y
+			}, WithMaxSize[string, int](1, tc.evict))
+			pq.MustPush("a", 1)
+
+			// a full priority queue doesn't make room for a lower priority entry.
+			if ok := pq.Offer("b", 5); ok {
+				t.Errorf("pq.Offer(%q, 5): got true on a full priority queue; want false", "b")
+			}
+			if pq.Contains("b") || pq.Len() != 1 {
+				t.Errorf("pq.Offer(%q, 5): got it in the priority queue with size %d; want it left out", "b", pq.Len())
+			}
+
+			// only an evicting priority queue makes room for a higher priority entry.
+			if ok := pq.Offer("c", 0); ok != tc.evict {
+				t.Errorf("pq.Offer(%q, 0): got %t; want %t", "c", ok, tc.evict)
+			}
+			if pq.Contains("c") != tc.evict {
+				t.Errorf("pq.Contains(%q): got %t; want %t", "c", pq.Contains("c"), tc.evict)
+			}
+		})
+	}
+}
+
+func TestKeyedPriorityQueue_PeekItem(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	if got, ok := pq.PeekItem(); ok || got != (Item[string, int]{}) {
+		t.Errorf("pq.PeekItem(): got (%v, %t); want zero Item and false", got, ok)
+	}
+
+	pq.MustPush("second", 20)
+	pq.MustPush("first", 10)
+
+	want := Item[string, int]{Key: "first", Value: 10}
+	if got, ok := pq.PeekItem(); !ok || got != want {
+		t.Errorf("pq.PeekItem(): got (%v, %t); want (%v, %t)", got, ok, want, true)
+	}
+
+	if got, want := pq.Len(), 2; got != want {
+		t.Errorf("pq.Len(): got %d; want unchanged %d", got, want)
+	}
+}
+
+func TestKeyedPriorityQueue_PeekWithLen(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y }, WithLazyDeletion[string, int](1))
+
+	if k, v, n, ok := pq.PeekWithLen(); ok || k != "" || v != 0 || n != 0 {
+		t.Errorf("pq.PeekWithLen(): got (%q, %d, %d, %t); want zero values and false", k, v, n, ok)
+	}
+
+	pq.MustPush("third", 30)
+	pq.MustPush("second", 20)
+	pq.MustPush("first", 10)
+	pq.Remove("third")
+
+	if k, v, n, ok := pq.PeekWithLen(); !ok || k != "first" || v != 10 || n != 2 {
+		t.Errorf("pq.PeekWithLen(): got (%q, %d, %d, %t); want (%q, %d, %d, %t)", k, v, n, ok, "first", 10, 2, true)
+	}
+}
+
+func TestKeyedPriorityQueue_PeekFunc(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithLazyDeletion[string, int](1))
+
+	even := func(k string, v int) bool {
+		return v%2 == 0
+	}
+
+	if got, ok := pq.PeekFunc(even); ok || got != (Item[string, int]{}) {
+		t.Errorf("pq.PeekFunc(even): got (%v, %t) on empty priority queue; want zero Item and false", got, ok)
+	}
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 7},
+		{key: "third", val: 9},
+		{key: "first", val: 5},
+		{key: "last", val: 20},
+		{key: "removed", val: 6},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+	pq.Remove("removed")
+
+	want := Item[string, int]{Key: "fourth", Value: 10}
+	if got, ok := pq.PeekFunc(even); !ok || got != want {
+		t.Errorf("pq.PeekFunc(even): got (%v, %t); want (%v, %t)", got, ok, want, true)
+	}
+
+	none := func(k string, v int) bool {
+		return v > 100
+	}
+	if got, ok := pq.PeekFunc(none); ok {
+		t.Errorf("pq.PeekFunc(none): got (%v, %t); want false", got, ok)
+	}
+
+	if got, want := pq.Len(), len(items)-1; got != want {
+		t.Errorf("pq.Len(): got %d; want unchanged %d", got, want)
+	}
+}
+
+func TestKeyedPriorityQueue_MinMax(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []Option[string, int]
+	}{
+		{name: "Default"},
+		{name: "LazyDeletion", opts: []Option[string, int]{WithLazyDeletion[string, int](1)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+				return x < y
+			}, tc.opts...)
+
+			if min, max, ok := pq.MinMax(); ok {
+				t.Errorf("pq.MinMax(): got (%v, %v, %t) on empty priority queue; want false", min, max, ok)
+			}
+
+			items := []struct {
+				key string
+				val int
+			}{
+				{key: "fourth", val: 10},
+				{key: "second", val: 8},
+				{key: "third", val: 9},
+				{key: "first", val: 6},
+				{key: "last", val: 20},
+				{key: "removed", val: 30},
+			}
+
+			for _, item := range items {
+				err := pq.Push(item.key, item.val)
+				if err != nil {
+					t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+				}
+			}
+			pq.Remove("removed")
+
+			wantMin, wantMax := Item[string, int]{Key: "first", Value: 6}, Item[string, int]{Key: "last", Value: 20}
+			if min, max, ok := pq.MinMax(); !ok || min != wantMin || max != wantMax {
+				t.Errorf("pq.MinMax(): got (%v, %v, %t); want (%v, %v, %t)", min, max, ok, wantMin, wantMax, true)
+			}
+		})
+	}
+}
+
+func TestKeyedPriorityQueue_Frontier(t *testing.T) {
+	testCases := []struct {
+		name      string
+		opts      []Option[int, int]
+		depth     int
+		wantCount int
+	}{
+		{name: "ZeroDepth", depth: 0, wantCount: 0},
+		{name: "Top", depth: 1, wantCount: 1},
+		{name: "TwoLevels", depth: 2, wantCount: 3},
+		{name: "ThreeLevels", depth: 3, wantCount: 7},
+		{name: "Ternary", opts: []Option[int, int]{WithArity[int, int](3)}, depth: 2, wantCount: 4},
+		{name: "WholeHeap", depth: 10, wantCount: 20},
+		{name: "HugeDepth", opts: []Option[int, int]{WithArity[int, int](1 << 20)}, depth: 100, wantCount: 20},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+				return x < y
+			}, tc.opts...)
+
+			r := rand.New(rand.NewSource(1))
+			for k := 0; k < 20; k++ {
+				pq.Push(k, r.Intn(100))
+			}
+
+			got := pq.Frontier(tc.depth)
+			if len(got) != tc.wantCount {
+				t.Fatalf("pq.Frontier(%d): got %d entries; want %d", tc.depth, len(got), tc.wantCount)
+			}
+			if len(got) == 0 {
+				return
+			}
+			if top, _ := pq.PeekItem(); got[0] != top {
+				t.Errorf("pq.Frontier(%d)[0]: got %v; want top %v", tc.depth, got[0], top)
+			}
+			for _, item := range got {
+				if v, _ := pq.ValueOf(item.Key); v != item.Value {
+					t.Errorf("pq.Frontier(%d): got %v; want value %d", tc.depth, item, v)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyedPriorityQueue_LevelOrder(t *testing.T) {
+	testCases := []struct {
+		name       string
+		opts       []Option[int, int]
+		wantWidths []int
+	}{
+		{name: "Binary", wantWidths: []int{1, 2, 4, 3}},
+		{name: "Ternary", opts: []Option[int, int]{WithArity[int, int](3)}, wantWidths: []int{1, 3, 6}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+				return x < y
+			}, tc.opts...)
+
+			if got := pq.LevelOrder(); got != nil {
+				t.Errorf("pq.LevelOrder(): got %v on empty priority queue; want nil", got)
+			}
+
+			r := rand.New(rand.NewSource(1))
+			for k := 0; k < 10; k++ {
+				pq.Push(k, r.Intn(100))
+			}
+
+			got := pq.LevelOrder()
+			if len(got) != len(tc.wantWidths) {
+				t.Fatalf("pq.LevelOrder(): got %d levels; want %d", len(got), len(tc.wantWidths))
+			}
+			for l, level := range got {
+				if len(level) != tc.wantWidths[l] {
+					t.Errorf("pq.LevelOrder()[%d]: got %d entries; want %d", l, len(level), tc.wantWidths[l])
+				}
+			}
+			if top, _ := pq.PeekItem(); got[0][0] != top {
+				t.Errorf("pq.LevelOrder()[0][0]: got %v; want top %v", got[0][0], top)
+			}
+
+			var flat []Item[int, int]
+			for _, level := range got {
+				flat = append(flat, level...)
+			}
+			want := pq.Frontier(len(got))
+			if len(flat) != len(want) {
+				t.Fatalf("pq.LevelOrder(): got %d entries; want %d", len(flat), len(want))
+			}
+			for i := range want {
+				if flat[i] != want[i] {
+					t.Errorf("pq.LevelOrder(): got %v at heap position %d; want %v", flat[i], i, want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestKeyedPriorityQueue_BottomN(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	}, WithLazyDeletion[int, int](1))
+
+	if got := pq.BottomN(3); got != nil {
+		t.Errorf("pq.BottomN(3): got %v on empty priority queue; want nil", got)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	m := 100
+	for k := 0; k < m; k++ {
+		pq.Push(k, r.Intn(1000))
+	}
+	for k := 0; k < m; k += 7 {
+		pq.Remove(k)
+	}
+
+	// want holds all the entries, from the lowest priority up.
+	want := pq.ItemsDesc()
+
+	for _, n := range []int{0, 1, 5, 30, len(want), len(want) + 10} {
+		got := pq.BottomN(n)
+		wantN := want[:min(n, len(want))]
+		if len(got) != len(wantN) {
+			t.Fatalf("pq.BottomN(%d): got %d entries; want %d", n, len(got), len(wantN))
+		}
+		for i := range wantN {
+			// ties may be broken either way, so only values are compared.
+			if got[i].Value != wantN[i].Value {
+				t.Errorf("pq.BottomN(%d)[%d]: got %v; want value %d", n, i, got[i], wantN[i].Value)
+			}
+		}
+	}
+
+	if got, want := pq.Len(), m-(m+6)/7; got != want {
+		t.Errorf("pq.Len(): got %d; want unchanged %d", got, want)
+	}
+}
+
+func TestKeyedPriorityQueue_PeekAt(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []Option[int, int]
+	}{
+		{name: "Default"},
+		{name: "Arity", opts: []Option[int, int]{WithArity[int, int](3)}},
+		{name: "LazyDeletion", opts: []Option[int, int]{WithLazyDeletion[int, int](1)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+				return x < y
+			}, tc.opts...)
+
+			for k := 0; k < 200; k++ {
+				pq.Push(k, r.Intn(1000))
+			}
+			for k := 0; k < 200; k += 3 {
+				pq.Remove(k)
+			}
+
+			want := pq.AppendSorted(nil)
+			for rank := range want {
+				got, ok := pq.PeekAt(rank)
+				if !ok {
+					t.Fatalf("pq.PeekAt(%d): got false; want true", rank)
+				}
+				if got.Value != want[rank].Value {
+					t.Errorf("pq.PeekAt(%d): got value %d; want %d", rank, got.Value, want[rank].Value)
+				}
+				if v, _ := pq.ValueOf(got.Key); v != got.Value {
+					t.Errorf("pq.PeekAt(%d): got key %d with value %d; want value %d", rank, got.Key, got.Value, v)
+				}
+			}
+
+			for _, rank := range []int{-1, len(want)} {
+				if _, ok := pq.PeekAt(rank); ok {
+					t.Errorf("pq.PeekAt(%d): got true; want false", rank)
+				}
+			}
+
+			if got, want := pq.Len(), len(want); got != want {
+				t.Errorf("pq.Len(): got %d; want unchanged %d", got, want)
+			}
+		})
+	}
+}
+
+func TestKeyedPriorityQueue_Pull(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []Option[string, int]
+	}{
+		{name: "Default"},
+		{name: "LazyDeletion", opts: []Option[string, int]{WithLazyDeletion[string, int](1)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+				return x < y
+			}, tc.opts...)
+			pq.MustPush("first", 10)
+			pq.MustPush("second", 20)
+			pq.MustPush("third", 30)
+
+			for _, k := range []string{"second", "first"} {
+				want, _ := pq.ValueOf(k)
+				if got, ok := pq.Pull(k); !ok || got != want {
+					t.Errorf("pq.Pull(%q): got (%d, %t); want (%d, %t)", k, got, ok, want, true)
+				}
+				if pq.Contains(k) {
+					t.Errorf("pq.Contains(%q): got true after Pull; want false", k)
+				}
+			}
+
+			if got, ok := pq.Pull("second"); ok || got != 0 {
+				t.Errorf("pq.Pull(%q): got (%d, %t); want (0, false)", "second", got, ok)
+			}
+
+			if got, want := pq.Len(), 1; got != want {
+				t.Errorf("pq.Len(): got %d; want %d", got, want)
+			}
+			if k, _ := pq.PeekKey(); k != "third" {
+				t.Errorf("pq.PeekKey(): got %q; want %q", k, "third")
+			}
+		})
+	}
+}
+
+func TestKeyedPriorityQueue_TopChangedReports(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	pushCases := []struct {
+		key         string
+		val         int
+		wantChanged bool
+		wantErr     bool
+	}{
+		{key: "second", val: 8, wantChanged: true},
+		{key: "third", val: 9, wantChanged: false},
+		{key: "first", val: 6, wantChanged: true},
+		{key: "third", val: 1, wantChanged: false, wantErr: true},
+	}
+	for _, tc := range pushCases {
+		changed, err := pq.PushReport(tc.key, tc.val)
+		if (err != nil) != tc.wantErr || changed != tc.wantChanged {
+			t.Errorf("pq.PushReport(%q, %d): got (%t, %v); want changed %t and error %t", tc.key, tc.val, changed, err, tc.wantChanged, tc.wantErr)
+		}
+	}
+
+	updateCases := []struct {
+		key         string
+		val         int
+		wantChanged bool
+		wantErr     bool
+	}{
+		{key: "third", val: 10, wantChanged: false},
+		{key: "first", val: 5, wantChanged: true},
+		{key: "third", val: 1, wantChanged: true},
+		{key: "missing", val: 1, wantChanged: false, wantErr: true},
+	}
+	for _, tc := range updateCases {
+		changed, err := pq.UpdateReport(tc.key, tc.val)
+		if (err != nil) != tc.wantErr || changed != tc.wantChanged {
+			t.Errorf("pq.UpdateReport(%q, %d): got (%t, %v); want changed %t and error %t", tc.key, tc.val, changed, err, tc.wantChanged, tc.wantErr)
+		}
+	}
+
+	removeCases := []struct {
+		key         string
+		wantChanged bool
+	}{
+		{key: "missing", wantChanged: false},
+		{key: "second", wantChanged: false},
+		{key: "third", wantChanged: true},
+		{key: "first", wantChanged: true},
+	}
+	for _, tc := range removeCases {
+		if changed := pq.RemoveReport(tc.key); changed != tc.wantChanged {
+			t.Errorf("pq.RemoveReport(%q): got %t; want %t", tc.key, changed, tc.wantChanged)
+		}
+	}
+}
+
+func TestKeyedPriorityQueue_DistinctValues(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithLazyDeletion[string, int](1))
+
+	eq := func(a, b int) bool {
+		return a == b
+	}
+	if got := pq.DistinctValues(eq); got != 0 {
+		t.Errorf("pq.DistinctValues(eq): got %d on empty priority queue; want 0", got)
+	}
+
+	for k, v := range map[string]int{"a": 1, "b": 2, "c": 2, "d": 3, "e": 3, "f": 3} {
+		pq.MustPush(k, v)
+	}
+	pq.Remove("d") // a tombstone, as d isn't the highest priority key
+
+	if got, want := pq.DistinctValues(eq), 3; got != want {
+		t.Errorf("pq.DistinctValues(eq): got %d; want %d", got, want)
+	}
+	if got, want := DistinctValuesComparable(pq), 3; got != want {
+		t.Errorf("DistinctValuesComparable(pq): got %d; want %d", got, want)
+	}
+
+	pq.Remove("a")
+	if got, want := pq.DistinctValues(eq), 2; got != want {
+		t.Errorf("pq.DistinctValues(eq): got %d after removing %q; want %d", got, "a", want)
+	}
+	if got, want := DistinctValuesComparable(pq), 2; got != want {
+		t.Errorf("DistinctValuesComparable(pq): got %d after removing %q; want %d", got, "a", want)
+	}
+}
+
+func TestKeyedPriorityQueue_Remove(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	t.Run("Keys", func(t *testing.T) {
+		testCases := []struct {
+			key           string
+			wantPeekKey   string
+			wantPeekValue int
+			wantLen       int
+		}{
+			{
+				key:           "first",
+				wantPeekKey:   "second",
+				wantPeekValue: 8,
+				wantLen:       4,
+			},
+			{
+				key:           "third",
+				wantPeekKey:   "second",
+				wantPeekValue: 8,
+				wantLen:       3,
+			},
+			{
+				key:           "second",
+				wantPeekKey:   "fourth",
+				wantPeekValue: 10,
+				wantLen:       2,
+			},
+			{
+				key:           "last",
+				wantPeekKey:   "fourth",
+				wantPeekValue: 10,
+				wantLen:       1,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.key, func(t *testing.T) {
+				pq.Remove(tc.key)
+
+				gotPeekKey, gotPeekValue, ok := pq.Peek()
+				if !ok {
+					t.Fatal("got no min key and value in the priority queue")
+				}
+
+				if gotPeekKey != tc.wantPeekKey {
+					t.Errorf("pq.Peek(): got key %q; want %q", gotPeekKey, tc.wantPeekKey)
+				}
+
+				if gotPeekValue != tc.wantPeekValue {
+					t.Errorf("pq.PeekValue(): got value %d; want %d", gotPeekValue, tc.wantPeekValue)
+				}
+
+				if got := pq.Len(); got != tc.wantLen {
+					t.Errorf("pq.Len(): got %d; want %d", got, tc.wantLen)
+				}
+			})
+		}
+	})
+
+	t.Run("NonExistingKey", func(t *testing.T) {
+		want := pq.Len()
+		pq.Remove("non-existing-key")
+
+		if got := pq.Len(); got != want {
+			t.Errorf("pq.Len(): got %d; want %d", got, want)
+		}
+	})
+}
+
+func TestKeyedPriorityQueue_Peek_EmptyQueue(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool { return x < y })
+
+	_, _, ok := pq.Peek()
+	if ok {
+		t.Error("pq.Peek(): got unexpected non empty priority queue")
+	}
+}
+
+func TestKeyedPriorityQueue_PeekValue_EmptyQueue(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool { return x < y })
+
+	_, ok := pq.PeekValue()
+	if ok {
+		t.Error("pq.PeekValue(): got unexpected non empty priority queue")
+	}
+}
+
+func TestKeyedPriorityQueue_PeekKey_EmptyQueue(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool { return x < y })
+
+	_, ok := pq.PeekKey()
+	if ok {
+		t.Error("pq.PeekKey(): got unexpected non empty priority queue")
+	}
+}
+
+func TestKeyedPriorityQeue_Contains(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	k := "user"
+	if err := pq.Push(k, 10); err != nil {
+		t.Fatalf("pq.Push(%q, 10): got unexpected error %v", k, err)
+	}
+
+	if ok := pq.Contains(k); !ok {
+		t.Errorf("pq.Contains(%q): got no key in priority queue", k)
+	}
+}
+
+func TestKeyedPriorityQueue_WaitForTop(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	due := func(v int) bool {
+		return v <= 5
+	}
+
+	type result struct {
+		k   string
+		v   int
+		err error
+	}
+	wait := func(ctx context.Context) <-chan result {
+		done := make(chan result, 1)
+		go func() {
+			k, v, err := pq.WaitForTop(ctx, due)
+			done <- result{k: k, v: v, err: err}
+		}()
+		return done
+	}
+
+	done := wait(context.Background())
+	pq.MustPush("first", 10)
+	pq.MustPush("second", 20)
+	if err := pq.Update("second", 3); err != nil {
+		t.Fatalf("pq.Update(%q, 3): got unexpected error %v", "second", err)
+	}
+
+	got := <-done
+	if got.err != nil || got.k != "second" || got.v != 3 {
+		t.Errorf("pq.WaitForTop(ctx, due): got (%q, %d, %v); want (%q, %d, <nil>)", got.k, got.v, got.err, "second", 3)
+	}
+	if got, want := pq.Len(), 2; got != want {
+		t.Errorf("pq.Len(): got %d; want %d", got, want)
+	}
+
+	pq.MustPop()
+	ctx, cancel := context.WithCancel(context.Background())
+	done = wait(ctx)
+	cancel()
+
+	got = <-done
+	if !errors.Is(got.err, context.Canceled) {
+		t.Errorf("pq.WaitForTop(ctx, due): got error %v after cancelling ctx; want %v", got.err, context.Canceled)
+	}
+}
+
+func TestKeyedPriorityQueue_ContainsAll(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	for _, k := range []string{"a", "b", "c"} {
+		if err := pq.Push(k, 10); err != nil {
+			t.Fatalf("pq.Push(%q, 10): got unexpected error %v", k, err)
+		}
+	}
+
+	testCases := []struct {
+		name string
+		keys []string
+		want bool
+	}{
+		{name: "NoKeys", keys: nil, want: true},
+		{name: "AllExisting", keys: []string{"a", "c"}, want: true},
+		{name: "SomeExisting", keys: []string{"a", "d"}, want: false},
+		{name: "NoneExisting", keys: []string{"d", "e"}, want: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := pq.ContainsAll(tc.keys...); got != tc.want {
+				t.Errorf("pq.ContainsAll(%q): got %t; want %t", tc.keys, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestKeyedPriorityQueue_ContainsAny(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	for _, k := range []string{"a", "b", "c"} {
+		if err := pq.Push(k, 10); err != nil {
+			t.Fatalf("pq.Push(%q, 10): got unexpected error %v", k, err)
+		}
+	}
+
+	testCases := []struct {
+		name string
+		keys []string
+		want bool
+	}{
+		{name: "NoKeys", keys: nil, want: false},
+		{name: "AllExisting", keys: []string{"a", "c"}, want: true},
+		{name: "SomeExisting", keys: []string{"d", "a"}, want: true},
+		{name: "NoneExisting", keys: []string{"d", "e"}, want: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := pq.ContainsAny(tc.keys...); got != tc.want {
+				t.Errorf("pq.ContainsAny(%q): got %t; want %t", tc.keys, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestKeyedPriorityQueue_ContainsEach(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	for _, k := range []string{"a", "b", "c"} {
+		if err := pq.Push(k, 10); err != nil {
+			t.Fatalf("pq.Push(%q, 10): got unexpected error %v", k, err)
+		}
+	}
+
+	keys := []string{"a", "d", "c", "a", "e"}
+	want := map[string]bool{"a": true, "c": true, "d": false, "e": false}
+
+	got := pq.ContainsEach(keys)
+	if len(got) != len(want) {
+		t.Fatalf("pq.ContainsEach(%q): got %v; want %v", keys, got, want)
+	}
+	for k, w := range want {
+		if g, ok := got[k]; !ok || g != w {
+			t.Errorf("pq.ContainsEach(%q)[%q]: got (%t, %t); want (%t, %t)", keys, k, g, ok, w, true)
+		}
+	}
+
+	if got := pq.ContainsEach(nil); len(got) != 0 {
+		t.Errorf("pq.ContainsEach(nil): got %v; want empty map", got)
+	}
+}
+
+func TestKeyedPriorityQueue_ValueOf(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	k, v := "user", 10
+	if err := pq.Push(k, v); err != nil {
+		t.Fatalf("pq.Push(%q, 10): got unexpected error %v", k, err)
+	}
+
+	t.Run("ExistingKey", func(t *testing.T) {
+		got, ok := pq.ValueOf(k)
+		if !ok {
+			t.Fatalf("pq.ValueOf(%q): got no key %q in priority queue", k, k)
+		}
+
+		if want := v; got != want {
+			t.Errorf("pq.ValueOf(%q): got unexpected value %v for key %q; want %v", k, got, k, want)
+		}
+	})
+
+	t.Run("NonExistingKey", func(t *testing.T) {
+		k := "non-existing-key"
+		_, ok := pq.ValueOf(k)
+		if ok {
+			t.Errorf("pq.Contains(%q): got unexpected key %q in priority queue", k, k)
+		}
+	})
+}
+
+func TestKeyedPriorityQueue_ValueOfMany(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	for i, k := range []string{"a", "b", "c"} {
+		if err := pq.Push(k, i); err != nil {
+			t.Fatalf("pq.Push(%q, %d): got unexpected error %v", k, i, err)
+		}
+	}
+
+	keys := []string{"a", "d", "c"}
+	want := map[string]int{"a": 0, "c": 2}
+
+	got := pq.ValueOfMany(keys)
+	if len(got) != len(want) {
+		t.Fatalf("pq.ValueOfMany(%q): got %v; want %v", keys, got, want)
+	}
+	for k, w := range want {
+		if g, ok := got[k]; !ok || g != w {
+			t.Errorf("pq.ValueOfMany(%q)[%q]: got (%d, %t); want (%d, %t)", keys, k, g, ok, w, true)
+		}
+	}
+}
+
+func TestKeyedPriorityQueue_IndexOf(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	for _, k := range []string{"b", "c", "a"} {
+		if err := pq.Push(k, int(k[0])); err != nil {
+			t.Fatalf("pq.Push(%q, %d): got unexpected error %v", k, int(k[0]), err)
+		}
+	}
+
+	got, ok := pq.IndexOf("a")
+	if !ok {
+		t.Fatalf("pq.IndexOf(%q): got no key in priority queue", "a")
+	}
+	if got != 0 {
+		t.Errorf("pq.IndexOf(%q): got %d; want %d", "a", got, 0)
+	}
+
+	for _, k := range []string{"b", "c"} {
+		i, ok := pq.IndexOf(k)
+		if !ok {
+			t.Fatalf("pq.IndexOf(%q): got no key in priority queue", k)
+		}
+		if pq.pm[i] != k {
+			t.Errorf("pq.IndexOf(%q): got position %d holding key %q", k, i, pq.pm[i])
+		}
+	}
+
+	if _, ok := pq.IndexOf("non-existing-key"); ok {
+		t.Errorf("pq.IndexOf(%q): got unexpected key in priority queue", "non-existing-key")
+	}
+}
+
+func TestKeyedPriorityQueue_GetOr(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	k, v := "user", 10
+	if err := pq.Push(k, v); err != nil {
+		t.Fatalf("pq.Push(%q, 10): got unexpected error %v", k, err)
+	}
+
+	if got := pq.GetOr(k, 99); got != v {
+		t.Errorf("pq.GetOr(%q, 99): got %d; want %d", k, got, v)
+	}
+
+	if got := pq.GetOr("non-existing-key", 99); got != 99 {
+		t.Errorf("pq.GetOr(%q, 99): got %d; want %d", "non-existing-key", got, 99)
+	}
+}
+
+func TestKeyedPriorityQueue_Range(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	testCases := []struct {
+		lo, hi int
+		want   map[string]int
+	}{
+		{lo: 8, hi: 10, want: map[string]int{"second": 8, "third": 9, "fourth": 10}},
+		{lo: 11, hi: 19, want: map[string]int{}},
+		{lo: 0, hi: 6, want: map[string]int{"first": 6}},
+		{lo: 10, hi: 8, want: map[string]int{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%d_%d", tc.lo, tc.hi), func(t *testing.T) {
+			got := pq.Range(tc.lo, tc.hi)
+			if len(got) != len(tc.want) {
+				t.Fatalf("pq.Range(%d, %d): got %v; want %v", tc.lo, tc.hi, got, tc.want)
+			}
+			for _, item := range got {
+				if v, ok := tc.want[item.Key]; !ok || v != item.Value {
+					t.Errorf("pq.Range(%d, %d): got unexpected item %v", tc.lo, tc.hi, item)
+				}
+			}
+		})
+	}
+}
+
+func TestKeyedPriorityQueue_CountFunc(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	})
+
+	for k := 0; k < 10; k++ {
+		pq.Push(k, k*10)
+	}
+
+	testCases := []struct {
+		name string
+		pred func(k, v int) bool
+		want int
+	}{
+		{name: "None", pred: func(k, v int) bool { return false }, want: 0},
+		{name: "All", pred: func(k, v int) bool { return true }, want: 10},
+		{name: "ValueAbove", pred: func(k, v int) bool { return v > 50 }, want: 4},
+		{name: "EvenKeys", pred: func(k, v int) bool { return k%2 == 0 }, want: 5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := pq.CountFunc(tc.pred); got != tc.want {
+				t.Errorf("pq.CountFunc(pred): got %d; want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestKeyedPriorityQueue_IsEmpty(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	k := "key"
+	if err := pq.Push(k, 10); err != nil {
+		t.Fatalf("pq.Push(%q, 10): got unexpected error %v", k, err)
+	}
+
+	if empty := pq.IsEmpty(); empty {
+		t.Fatal("pq.IsEmpty(): got unexpected empty priority queue")
+	}
+
+	pq.Remove(k)
+
+	if empty := pq.IsEmpty(); !empty {
+		t.Fatal("pq.IsEmpty(): got unexpected non-empty priority queue")
+	}
+}
+
+func TestKeyedPriorityQueue_Len(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				k := i*100 + j
+				pq.Push(k, j)
+				pq.Len()
+				if j%2 == 0 {
+					pq.Remove(k)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got, want := pq.Len(), len(pq.pm); got != want {
+		t.Errorf("pq.Len(): got %d; want %d", got, want)
+	}
+
+	for pq.Len() > 0 {
+		if _, _, ok := pq.Pop(); !ok {
+			t.Fatal("pq.Pop(): got unexpected empty priority queue")
+		}
+	}
+
+	if got, want := pq.Len(), len(pq.pm); got != want {
+		t.Errorf("pq.Len(): got %d; want %d", got, want)
+	}
+}
+
+func TestKeyedPriorityQueue_Set(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	testCases := []struct {
+		k string
+		v int
+	}{
+		{"last", 5},
+		{"new_first", 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.k, func(t *testing.T) {
+			pq.Set(tc.k, tc.v)
+
+			if got, _ := pq.PeekValue(); got != tc.v {
+				t.Errorf("pq.PeekValue(): got value %d; want %d", got, tc.v)
+			}
+		})
+	}
+
+}
+
+func TestKeyedPriorityQueue_SetAndGet(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	testCases := []struct {
+		k           string
+		v           int
+		wantOld     int
+		wantExisted bool
+	}{
+		{k: "key", v: 10, wantOld: 0, wantExisted: false},
+		{k: "key", v: 5, wantOld: 10, wantExisted: true},
+		{k: "other", v: 1, wantOld: 0, wantExisted: false},
+		{k: "key", v: 20, wantOld: 5, wantExisted: true},
+	}
+
+	for _, tc := range testCases {
+		old, existed := pq.SetAndGet(tc.k, tc.v)
+		if old != tc.wantOld || existed != tc.wantExisted {
+			t.Errorf("pq.SetAndGet(%q, %d): got (%d, %t); want (%d, %t)", tc.k, tc.v, old, existed, tc.wantOld, tc.wantExisted)
+		}
+		if got, _ := pq.ValueOf(tc.k); got != tc.v {
+			t.Errorf("pq.ValueOf(%q): got %d; want %d", tc.k, got, tc.v)
+		}
+	}
+
+	if k, _ := pq.PeekKey(); k != "other" {
+		t.Errorf("pq.PeekKey(): got %q; want %q", k, "other")
+	}
+}
+
+func TestKeyedPriorityQueue_Items(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithStableOrdering[string, int]())
+
+	if got := pq.Items(); got != nil {
+		t.Errorf("pq.Items(): got %v on empty priority queue; want nil", got)
+	}
+	if got := pq.ItemsDesc(); got != nil {
+		t.Errorf("pq.ItemsDesc(): got %v on empty priority queue; want nil", got)
+	}
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 8},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	want := []Item[string, int]{
+		{Key: "first", Value: 6},
+		{Key: "second", Value: 8},
+		{Key: "third", Value: 8},
+		{Key: "fourth", Value: 10},
+		{Key: "last", Value: 20},
+	}
+
+	got := pq.Items()
+	if len(got) != len(want) {
+		t.Fatalf("pq.Items(): got %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("pq.Items()[%d]: got %v; want %v", i, got[i], want[i])
+		}
+	}
+
+	got = pq.ItemsDesc()
+	if len(got) != len(want) {
+		t.Fatalf("pq.ItemsDesc(): got %v; want reversed %v", got, want)
+	}
+	for i := range want {
+		if w := want[len(want)-1-i]; got[i] != w {
+			t.Errorf("pq.ItemsDesc()[%d]: got %v; want %v", i, got[i], w)
+		}
+	}
+
+	if got, want := pq.Len(), len(items); got != want {
+		t.Errorf("pq.Len(): got %d; want unchanged %d", got, want)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	less := func(x, y int) bool {
+		return x < y
+	}
+	pending := NewKeyedPriorityQueue[string](less, WithLazyDeletion[string, int](1))
+	active := NewKeyedPriorityQueue[string](less, WithMaxSize[string, int](2, false))
+
+	pending.MustPush("first", 1)
+	pending.MustPush("second", 2)
+	pending.MustPush("third", 3)
+	pending.MustPush("fourth", 4)
+	active.MustPush("third", 30)
+
+	var notFound KeyNotFoundError[string]
+	if err := Transfer(pending, active, "missing"); !errors.As(err, &notFound) {
+		t.Errorf("Transfer(pending, active, %q): got error type %T; want it to be %T", "missing", err, notFound)
+	}
+	var exists KeyAlreadyExistsError[string]
+	if err := Transfer(pending, active, "third"); !errors.As(err, &exists) {
+		t.Errorf("Transfer(pending, active, %q): got error type %T; want it to be %T", "third", err, exists)
+	}
+	if err := Transfer(pending, pending, "first"); err != nil {
+		t.Errorf("Transfer(pending, pending, %q): got unexpected error %v", "first", err)
+	}
+	if err := Transfer(pending, pending, "missing"); !errors.As(err, &notFound) {
+		t.Errorf("Transfer(pending, pending, %q): got error type %T; want it to be %T", "missing", err, notFound)
+	}
+
+	if err := Transfer(pending, active, "second"); err != nil {
+		t.Fatalf("Transfer(pending, active, %q): got unexpected error %v", "second", err)
+	}
+	if pending.Contains("second") {
+		t.Errorf("pending.Contains(%q): got true after transferring it; want false", "second")
+	}
+	if v, ok := active.ValueOf("second"); !ok || v != 2 {
+		t.Errorf("active.ValueOf(%q): got (%d, %t); want (%d, %t)", "second", v, ok, 2, true)
+	}
+
+	var full CapacityExceededError[string]
+	if err := Transfer(pending, active, "fourth"); !errors.As(err, &full) {
+		t.Errorf("Transfer(pending, active, %q): got error type %T; want it to be %T", "fourth", err, full)
+	}
+	if !pending.Contains("fourth") {
+		t.Errorf("pending.Contains(%q): got false after a failed transfer; want true", "fourth")
+	}
+
+	if got, want := pending.Len(), 3; got != want {
+		t.Errorf("pending.Len(): got %d; want %d", got, want)
+	}
+	for _, pq := range []*KeyedPriorityQueue[string, int]{pending, active} {
+		if err := pq.CheckInvariant(); err != nil {
+			t.Errorf("pq.CheckInvariant(): got unexpected error %v", err)
+		}
+	}
+}
+
+func TestTransfer_EvictingMaxSize(t *testing.T) {
+	less := func(x, y int) bool {
+		return x < y
+	}
+	from := NewKeyedPriorityQueue[string](less)
+	to := NewKeyedPriorityQueue[string](less, WithMaxSize[string, int](1, true))
+
+	from.MustPush("a", 5)
+	from.MustPush("b", 0)
+	to.MustPush("x", 1)
+
+	var full CapacityExceededError[string]
+	if err := Transfer(from, to, "a"); !errors.As(err, &full) {
+		t.Errorf("Transfer(from, to, %q): got error type %T; want it to be %T", "a", err, full)
+	}
+	if !from.Contains("a") || to.Contains("a") {
+		t.Errorf("Transfer(from, to, %q): got it in from %t and to %t; want it left in from only", "a", from.Contains("a"), to.Contains("a"))
+	}
+
+	// b has a higher priority than x, which is evicted to make room for it.
+	if err := Transfer(from, to, "b"); err != nil {
+		t.Fatalf("Transfer(from, to, %q): got unexpected error %v", "b", err)
+	}
+	if from.Contains("b") || !to.Contains("b") || to.Contains("x") {
+		t.Errorf("Transfer(from, to, %q): got keys %v in to; want [b]", "b", to.AppendKeys(nil))
+	}
+}
+
+func TestKeyedPriorityQueue_CopyFrom(t *testing.T) {
+	src := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithStableOrdering[string, int](), WithLazyDeletion[string, int](1))
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 8},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+		{key: "removed", val: 1},
+	}
+
+	for _, item := range items {
+		err := src.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+	src.Remove("removed")
+
+	t.Run("SameOrdering", func(t *testing.T) {
+		pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+			return x < y
+		}, WithStableOrdering[string, int]())
+		pq.MustPush("old", 0)
+
+		pq.CopyFrom(src)
+
+		if pq.Contains("old") || pq.Contains("removed") {
+			t.Error("pq.CopyFrom(src): got stale entries; want only the entries of src")
+		}
+		if err := pq.CheckInvariant(); err != nil {
+			t.Errorf("pq.CheckInvariant(): got %v; want nil", err)
+		}
+		for _, want := range []string{"first", "second", "third", "fourth", "last"} {
+			if k, _ := pq.MustPop(); k != want {
+				t.Errorf("pq.MustPop(): got key %q; want %q", k, want)
+			}
+		}
+		if got, want := src.Len(), len(items)-1; got != want {
+			t.Errorf("src.Len(): got %d; want unchanged %d", got, want)
+		}
+	})
+
+	t.Run("OwnOrdering", func(t *testing.T) {
+		var evicted []string
+		pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+			return x > y
+		}, WithMaxSize[string, int](2, false), WithOnEvict(func(k string, v int, reason EvictReason) {
+			evicted = append(evicted, k)
+		}))
+
+		pq.CopyFrom(src)
+
+		if got, want := pq.Len(), 2; got != want {
 			t.Errorf("pq.Len(): got %d; want %d", got, want)
 		}
+		if got, want := len(evicted), len(items)-3; got != want {
+			t.Errorf("pq.CopyFrom(src): got %d discarded entries; want %d", got, want)
+		}
+		if err := pq.CheckInvariant(); err != nil {
+			t.Errorf("pq.CheckInvariant(): got %v; want nil", err)
+		}
+		if k, _, _ := pq.Peek(); !src.Contains(k) {
+			t.Errorf("pq.Peek(): got key %q; want a key of src", k)
+		}
 	})
 }
 
-func TestKeyedPriorityQueue_Peek_EmptyQueue(t *testing.T) {
-	pq := NewKeyedPriorityQueue[int](func(x, y int) bool { return x < y })
+func TestKeyedPriorityQueue_Swap(t *testing.T) {
+	cmp := func(x, y int) bool {
+		return x < y
+	}
 
-	_, _, ok := pq.Peek()
-	if ok {
-		t.Error("pq.Peek(): got unexpected non empty priority queue")
+	testCases := []struct {
+		name      string
+		opts      []Option[string, int]
+		otherOpts []Option[string, int]
+	}{
+		{name: "Default"},
+		{name: "StableOrdering", opts: []Option[string, int]{WithStableOrdering[string, int]()}},
+		{name: "LazyDeletion", otherOpts: []Option[string, int]{WithLazyDeletion[string, int](1)}},
+		{name: "Arity", opts: []Option[string, int]{WithArity[string, int](4)}},
+		{name: "OtherArity", otherOpts: []Option[string, int]{WithArity[string, int](4)}},
 	}
-}
 
-func TestKeyedPriorityQueue_PeekValue_EmptyQueue(t *testing.T) {
-	pq := NewKeyedPriorityQueue[int](func(x, y int) bool { return x < y })
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := NewKeyedPriorityQueue[string](cmp, tc.opts...)
+			// in this order, the entries form a binary heap that isn't a 4-ary heap, and vice versa.
+			for _, v := range []int{0, 3, 4, 5, 6, 1, 2} {
+				pq.MustPush(fmt.Sprint("a", v), v)
+			}
 
-	_, ok := pq.PeekValue()
-	if ok {
-		t.Error("pq.PeekValue(): got unexpected non empty priority queue")
+			other := NewKeyedPriorityQueue[string](cmp, tc.otherOpts...)
+			other.MustPush("first", 6)
+			other.MustPush("fourth", 9)
+			other.MustPush("fifth", 10)
+			other.MustPush("sixth", 11)
+			other.MustPush("seventh", 12)
+			other.MustPush("second", 7)
+			other.MustPush("third", 8)
+			other.MustPush("removed", 1)
+			other.Remove("removed")
+
+			pq.Swap(other)
+
+			if got, want := pq.Len(), 7; got != want {
+				t.Errorf("pq.Len(): got %d; want %d", got, want)
+			}
+			if got, want := other.Len(), 7; got != want {
+				t.Errorf("other.Len(): got %d; want %d", got, want)
+			}
+			for _, q := range []*KeyedPriorityQueue[string, int]{pq, other} {
+				if err := q.CheckInvariant(); err != nil {
+					t.Errorf("CheckInvariant(): got %v; want nil", err)
+				}
+			}
+			if pq.Contains("removed") {
+				t.Errorf("pq.Contains(%q): got true; want false", "removed")
+			}
+
+			for _, want := range []string{"first", "second", "third", "fourth", "fifth", "sixth", "seventh"} {
+				if k, _ := pq.MustPop(); k != want {
+					t.Errorf("pq.MustPop(): got key %q; want %q", k, want)
+				}
+			}
+			for v := 0; v < 7; v++ {
+				if k, _ := other.MustPop(); k != fmt.Sprint("a", v) {
+					t.Errorf("other.MustPop(): got key %q; want %q", k, fmt.Sprint("a", v))
+				}
+			}
+		})
 	}
 }
 
-func TestKeyedPriorityQueue_PeekKey_EmptyQueue(t *testing.T) {
-	pq := NewKeyedPriorityQueue[int](func(x, y int) bool { return x < y })
+func TestKeyedPriorityQueue_Swap_Concurrent(t *testing.T) {
+	cmp := func(x, y int) bool {
+		return x < y
+	}
 
-	_, ok := pq.PeekKey()
-	if ok {
-		t.Error("pq.PeekKey(): got unexpected non empty priority queue")
+	var mu sync.Mutex
+	testCases := []struct {
+		name string
+		opts []Option[int, int]
+	}{
+		{name: "OwnLocks"},
+		{name: "SharedLock", opts: []Option[int, int]{WithLocker[int, int](&mu)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := NewKeyedPriorityQueue[int](cmp, tc.opts...)
+			b := NewKeyedPriorityQueue[int](cmp, tc.opts...)
+			a.MustPush(1, 1)
+
+			var wg sync.WaitGroup
+			for i := 0; i < 100; i++ {
+				wg.Add(2)
+				go func() {
+					defer wg.Done()
+					a.Swap(b)
+				}()
+				go func() {
+					defer wg.Done()
+					b.Swap(a)
+				}()
+			}
+			wg.Wait()
+
+			if got, want := a.Len()+b.Len(), 1; got != want {
+				t.Errorf("a.Len()+b.Len(): got %d; want %d", got, want)
+			}
+		})
 	}
 }
 
-func TestKeyedPriorityQeue_Contains(t *testing.T) {
-	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+func TestKeyedPriorityQueue_AppendKeys(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithLazyDeletion[string, int](1))
 
-	k := "user"
-	if err := pq.Push(k, 10); err != nil {
-		t.Fatalf("pq.Push(%q, 10): got unexpected error %v", k, err)
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
 	}
 
-	if ok := pq.Contains(k); !ok {
-		t.Errorf("pq.Contains(%q): got no key in priority queue", k)
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+	pq.Remove("third")
+
+	dst := make([]string, 1, 16)
+	dst[0] = "prefix"
+
+	got := pq.AppendKeys(dst)
+
+	if len(got) != len(items) {
+		t.Fatalf("pq.AppendKeys(dst): got %v; want %d keys", got, len(items))
+	}
+	if got[0] != "prefix" || got[1] != "first" {
+		t.Errorf("pq.AppendKeys(dst): got %v; want prefix followed by %q first", got, "first")
+	}
+	sort.Strings(got[1:])
+	want := []string{"first", "fourth", "last", "second"}
+	for i := range want {
+		if got[i+1] != want[i] {
+			t.Errorf("pq.AppendKeys(dst)[%d]: got %q; want %q", i+1, got[i+1], want[i])
+		}
+	}
+	if &got[0] != &dst[0] {
+		t.Error("pq.AppendKeys(dst): got reallocated slice; want dst to be reused")
+	}
+
+	if allocs := testing.AllocsPerRun(10, func() { pq.AppendKeys(dst[:0]) }); allocs != 0 {
+		t.Errorf("pq.AppendKeys(dst): got %v allocations; want 0", allocs)
 	}
 }
 
-func TestKeyedPriorityQueue_ValueOf(t *testing.T) {
-	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+func TestKeyedPriorityQueue_Reversed(t *testing.T) {
+	testCases := []struct {
+		name string
+		new  func(opts ...Option[string, int]) *KeyedPriorityQueue[string, int]
+	}{
+		{name: "Cmp", new: func(opts ...Option[string, int]) *KeyedPriorityQueue[string, int] {
+			return NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y }, opts...)
+		}},
+		{name: "Compare", new: func(opts ...Option[string, int]) *KeyedPriorityQueue[string, int] {
+			return NewWithCompare[string](func(x, y int) int { return x - y }, opts...)
+		}},
+	}
 
-	k, v := "user", 10
-	if err := pq.Push(k, v); err != nil {
-		t.Fatalf("pq.Push(%q, 10): got unexpected error %v", k, err)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := tc.new(WithStableOrdering[string, int](), WithLazyDeletion[string, int](1))
+
+			items := []struct {
+				key string
+				val int
+			}{
+				{key: "fourth", val: 10},
+				{key: "second", val: 8},
+				{key: "third", val: 9},
+				{key: "first", val: 6},
+				{key: "last", val: 20},
+				{key: "tie", val: 8},
+				{key: "removed", val: 30},
+			}
+
+			for _, item := range items {
+				err := pq.Push(item.key, item.val)
+				if err != nil {
+					t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+				}
+			}
+			pq.Remove("removed")
+
+			rev := pq.Reversed()
+
+			if err := rev.CheckInvariant(); err != nil {
+				t.Errorf("rev.CheckInvariant(): got %v; want nil", err)
+			}
+			for _, want := range []string{"last", "fourth", "third", "second", "tie", "first"} {
+				if k, _ := rev.MustPop(); k != want {
+					t.Errorf("rev.MustPop(): got key %q; want %q", k, want)
+				}
+			}
+
+			if got, want := pq.Len(), len(items)-1; got != want {
+				t.Errorf("pq.Len(): got %d; want unchanged %d", got, want)
+			}
+			if k, _, _ := pq.Peek(); k != "first" {
+				t.Errorf("pq.Peek(): got key %q; want %q", k, "first")
+			}
+		})
 	}
+}
 
-	t.Run("ExistingKey", func(t *testing.T) {
-		got, ok := pq.ValueOf(k)
-		if !ok {
-			t.Fatalf("pq.ValueOf(%q): got no key %q in priority queue", k, k)
-		}
+func TestKeyedPriorityQueue_Partition(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	}, WithLazyDeletion[int, int](1), WithStableOrdering[int, int]())
 
-		if want := v; got != want {
-			t.Errorf("pq.ValueOf(%q): got unexpected value %v for key %q; want %v", k, got, k, want)
+	r := rand.New(rand.NewSource(1))
+	n := 100
+	for k := 0; k < n; k++ {
+		pq.Push(k, r.Intn(10))
+	}
+	for k := 0; k < n; k += 10 {
+		pq.Remove(k)
+	}
+
+	even := func(k int, v int) bool {
+		return k%2 == 0
+	}
+	part := pq.Partition(even)
+
+	if got, want := pq.Len(), n/2; got != want {
+		t.Errorf("pq.Len(): got %d; want %d", got, want)
+	}
+	if got, want := part.Len(), n/2-n/10; got != want {
+		t.Errorf("part.Len(): got %d; want %d", got, want)
+	}
+
+	for name, q := range map[string]*KeyedPriorityQueue[int, int]{"pq": pq, "part": part} {
+		if err := q.CheckInvariant(); err != nil {
+			t.Errorf("%s.CheckInvariant(): got %v; want nil", name, err)
 		}
-	})
 
-	t.Run("NonExistingKey", func(t *testing.T) {
-		k := "non-existing-key"
-		_, ok := pq.ValueOf(k)
-		if ok {
-			t.Errorf("pq.Contains(%q): got unexpected key %q in priority queue", k, k)
+		var last Item[int, int]
+		for i := 0; !q.IsEmpty(); i++ {
+			k, v := q.MustPop()
+			if wantEven := name == "part"; (k%2 == 0) != wantEven {
+				t.Errorf("%s.MustPop(): got key %d; want only even keys: %t", name, k, wantEven)
+			}
+			if k%10 == 0 {
+				t.Errorf("%s.MustPop(): got removed key %d", name, k)
+			}
+			// ties keep their insertion order, i.e., increasing keys.
+			if i > 0 && (v < last.Value || v == last.Value && k < last.Key) {
+				t.Errorf("%s.MustPop(): got (%d, %d) after (%d, %d); want priority and insertion order", name, k, v, last.Key, last.Value)
+			}
+			last = Item[int, int]{Key: k, Value: v}
 		}
-	})
+	}
 }
 
-func TestKeyedPriorityQueue_IsEmpty(t *testing.T) {
+func TestKeyedPriorityQueue_AppendSorted(t *testing.T) {
 	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
 		return x < y
 	})
 
-	k := "key"
-	if err := pq.Push(k, 10); err != nil {
-		t.Fatalf("pq.Push(%q, 10): got unexpected error %v", k, err)
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
 	}
 
-	if empty := pq.IsEmpty(); empty {
-		t.Fatal("pq.IsEmpty(): got unexpected empty priority queue")
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
 	}
 
-	pq.Remove(k)
+	prefix := Item[string, int]{Key: "prefix", Value: 100}
+	dst := make([]Item[string, int], 1, 16)
+	dst[0] = prefix
 
-	if empty := pq.IsEmpty(); !empty {
-		t.Fatal("pq.IsEmpty(): got unexpected non-empty priority queue")
+	got := pq.AppendSorted(dst)
+
+	want := []Item[string, int]{
+		prefix,
+		{Key: "first", Value: 6},
+		{Key: "second", Value: 8},
+		{Key: "third", Value: 9},
+		{Key: "fourth", Value: 10},
+		{Key: "last", Value: 20},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("pq.AppendSorted(dst): got %d items; want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("pq.AppendSorted(dst)[%d]: got %v; want %v", i, got[i], want[i])
+		}
+	}
+
+	if &got[0] != &dst[0] {
+		t.Error("pq.AppendSorted(dst): got reallocated slice; want dst to be reused")
+	}
+
+	if got, want := pq.Len(), len(items); got != want {
+		t.Errorf("pq.Len(): got %d; want %d", got, want)
 	}
 }
 
-func TestKeyedPriorityQueue_Set(t *testing.T) {
+func TestKeyedPriorityQueue_MapValues(t *testing.T) {
 	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
 		return x < y
 	})
@@ -462,30 +3336,183 @@ func TestKeyedPriorityQueue_Set(t *testing.T) {
 		}
 	}
 
+	// negating the values reverses the priority order.
+	mapped := pq.MapValues(func(k string, v int) int {
+		return -v
+	})
+
+	wantKeys := []string{"last", "fourth", "third", "second", "first"}
+	for _, want := range wantKeys {
+		got, v, ok := mapped.Pop()
+		if !ok {
+			t.Fatal("mapped.Pop(): got unexpected empty priority queue")
+		}
+		if got != want {
+			t.Errorf("mapped.Pop(): got key %q; want %q", got, want)
+		}
+		if orig, _ := pq.ValueOf(got); v != -orig {
+			t.Errorf("mapped.Pop(): got value %d for key %q; want %d", v, got, -orig)
+		}
+	}
+
+	if got, want := pq.Len(), len(items); got != want {
+		t.Errorf("pq.Len(): got %d; want %d", got, want)
+	}
+	if got, _ := pq.PeekKey(); got != "first" {
+		t.Errorf("pq.PeekKey(): got %q; want %q", got, "first")
+	}
+}
+
+func TestKeyedPriorityQueue_Stats(t *testing.T) {
 	testCases := []struct {
-		k string
-		v int
+		name string
+		opts []Option[int, int]
+		n    int
+		want QueueStats
 	}{
-		{"last", 5},
-		{"new_first", 1},
+		{name: "Empty", opts: []Option[int, int]{WithCapacity[int, int](4)}, want: QueueStats{Cap: 4}},
+		{name: "Root", opts: []Option[int, int]{WithCapacity[int, int](4)}, n: 1, want: QueueStats{Len: 1, Cap: 4, Height: 1}},
+		{name: "FullLevels", opts: []Option[int, int]{WithCapacity[int, int](8)}, n: 7, want: QueueStats{Len: 7, Cap: 8, Height: 3}},
+		{name: "PartialLevel", opts: []Option[int, int]{WithCapacity[int, int](8)}, n: 8, want: QueueStats{Len: 8, Cap: 8, Height: 4}},
+		{name: "Ternary", opts: []Option[int, int]{WithCapacity[int, int](16), WithArity[int, int](3)}, n: 13, want: QueueStats{Len: 13, Cap: 16, Height: 3}},
 	}
 
 	for _, tc := range testCases {
-		t.Run(tc.k, func(t *testing.T) {
-			pq.Set(tc.k, tc.v)
+		t.Run(tc.name, func(t *testing.T) {
+			pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+				return x < y
+			}, tc.opts...)
+			for k := 0; k < tc.n; k++ {
+				pq.MustPush(k, k)
+			}
 
-			if got, _ := pq.PeekValue(); got != tc.v {
-				t.Errorf("pq.PeekValue(): got value %d; want %d", got, tc.v)
+			if got := pq.Stats(); got != tc.want {
+				t.Errorf("pq.Stats(): got %+v; want %+v", got, tc.want)
 			}
 		})
 	}
+}
+
+func TestKeyedPriorityQueue_Stats_LazyDeletion(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	}, WithCapacity[int, int](8), WithLazyDeletion[int, int](1))
+	for k := 0; k < 8; k++ {
+		pq.MustPush(k, k)
+	}
+	pq.Remove(6)
+	pq.Remove(7)
+
+	want := QueueStats{Len: 6, Cap: 8, Height: 4, TombstoneCount: 2}
+	if got := pq.Stats(); got != want {
+		t.Errorf("pq.Stats(): got %+v; want %+v", got, want)
+	}
+}
+
+func TestKeyedPriorityQueue_Reserve(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	for _, k := range []string{"a", "b", "c"} {
+		if err := pq.Push(k, len(k)); err != nil {
+			t.Fatalf("pq.Push(%q, %d): got unexpected error %v", k, len(k), err)
+		}
+	}
+
+	pq.Reserve(100)
+	if got, want := cap(pq.pm), 100; got != want {
+		t.Errorf("cap(pq.pm) after pq.Reserve(100): got %d; want %d", got, want)
+	}
+
+	pq.Reserve(10)
+	if got, want := cap(pq.pm), 100; got != want {
+		t.Errorf("cap(pq.pm) after pq.Reserve(10): got %d; want %d", got, want)
+	}
+
+	if got, want := pq.Len(), 3; got != want {
+		t.Errorf("pq.Len(): got %d; want %d", got, want)
+	}
+
+	for _, k := range []string{"a", "b", "c"} {
+		if !pq.Contains(k) {
+			t.Errorf("pq.Contains(%q): got no key in priority queue", k)
+		}
+	}
+}
+
+func TestKeyedPriorityQueue_TrimToSize(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	})
+
+	for k := 0; k < 100; k++ {
+		pq.Push(k, 100-k)
+	}
+	for i := 0; i < 95; i++ {
+		pq.Pop()
+	}
+
+	if got, want := pq.Cap(), 100; got < want {
+		t.Fatalf("pq.Cap(): got %d; want at least %d", got, want)
+	}
+
+	pq.TrimToSize()
+
+	if got, want := pq.Cap(), 5; got != want {
+		t.Errorf("pq.Cap(): got %d; want %d", got, want)
+	}
+
+	for wantKey := 4; wantKey >= 0; wantKey-- {
+		gotKey, gotVal, ok := pq.Pop()
+		if !ok {
+			t.Fatal("pq.Pop(): got unexpected empty priority queue")
+		}
+		if gotKey != wantKey || gotVal != 100-wantKey {
+			t.Errorf("pq.Pop(): got (%d, %d); want (%d, %d)", gotKey, gotVal, wantKey, 100-wantKey)
+		}
+	}
+}
+
+func TestKeyedPriorityQueue_Compact(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	}, WithLazyDeletion[int, int](1))
+
+	for k := 0; k < 100; k++ {
+		pq.Push(k, 100-k)
+	}
+	for k := 10; k < 100; k++ {
+		pq.Remove(k)
+	}
+
+	pq.Compact()
+
+	if got, want := pq.Cap(), 10; got != want {
+		t.Errorf("pq.Cap(): got %d; want %d", got, want)
+	}
+	if got := len(pq.dead); got != 0 {
+		t.Errorf("pq.Compact(): got %d tombstones; want 0", got)
+	}
+	if got, want := len(pq.im), 10; got != want {
+		t.Errorf("pq.Compact(): got %d indexes; want %d", got, want)
+	}
 
+	for wantKey := 9; wantKey >= 0; wantKey-- {
+		gotKey, gotVal, ok := pq.Pop()
+		if !ok {
+			t.Fatal("pq.Pop(): got unexpected empty priority queue")
+		}
+		if gotKey != wantKey || gotVal != 100-wantKey {
+			t.Errorf("pq.Pop(): got (%d, %d); want (%d, %d)", gotKey, gotVal, wantKey, 100-wantKey)
+		}
+	}
 }
 
-func benchmarkKeyedPriorityQueue_PushPop(b *testing.B, n int) {
+func benchmarkKeyedPriorityQueue_PushPop(b *testing.B, n int, opts ...Option[int, int]) {
 	pq := NewKeyedPriorityQueue[int](func(a, b int) bool {
 		return a > b
-	})
+	}, opts...)
 	for i := 0; i < b.N; i++ {
 		for j := 0; j < n; j++ {
 			pq.Push(j, i)
@@ -515,3 +3542,34 @@ func BenchmarkKeyedPriorityQueue_PushPop_100000(b *testing.B) {
 func BenchmarkKeyedPriorityQueue_PushPop_1000000(b *testing.B) {
 	benchmarkKeyedPriorityQueue_PushPop(b, 1000000)
 }
+
+func BenchmarkKeyedPriorityQueue_PushPop_Arity(b *testing.B) {
+	for _, d := range []int{2, 4, 8} {
+		for _, n := range []int{1000, 100000} {
+			b.Run(fmt.Sprintf("d=%d/n=%d", d, n), func(b *testing.B) {
+				benchmarkKeyedPriorityQueue_PushPop(b, n, WithArity[int, int](d))
+			})
+		}
+	}
+}
+
+func BenchmarkKeyedPriorityQueue_UpdateValueOf(b *testing.B) {
+	for _, n := range []int{1000, 100000} {
+		b.Run(fmt.Sprintf("n=%d", n), func(b *testing.B) {
+			pq := NewKeyedPriorityQueue[int](func(a, b int) bool {
+				return a < b
+			})
+			for k := 0; k < n; k++ {
+				pq.Push(k, k)
+			}
+			r := rand.New(rand.NewSource(1))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				k := r.Intn(n)
+				v, _ := pq.ValueOf(k)
+				pq.Update(k, v+r.Intn(2*n)-n)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package kpq
+
+import "sync"
+
+// KeyFuncPriorityQueue represents a keyed priority queue whose keys are items of any type T,
+// including types that aren't comparable, such as structs holding slices.
+// Each item is identified by the string derived from it by a key function,
+// so two items with the same derived key are the same key of the priority queue.
+// The original items are stored along with their priority values, and returned by Pop and Peek.
+//
+// KeyFuncPriorityQueue is built on a KeyedPriorityQueue keyed by the derived strings.
+// Its operations have the same time complexity, plus a call of the key function for each given item.
+//
+// KeyFuncPriorityQueue is safe for concurrent use.
+type KeyFuncPriorityQueue[T any, V any] struct {
+	mu sync.RWMutex
+
+	keyFn func(T) string
+	items map[string]T                   // original item of each derived key
+	pq    *KeyedPriorityQueue[string, V] // keyed by the derived keys
+}
+
+// NewWithKeyFunc returns a new keyed priority queue whose keys are items of type T, identified by keyFn,
+// that uses the given cmp function for ordering the priority queue.
+//
+// NewWithKeyFunc will panic if keyFn or cmp is nil.
+func NewWithKeyFunc[T any, V any](keyFn func(T) string, cmp CmpFunc[V]) *KeyFuncPriorityQueue[T, V] {
+	if keyFn == nil {
+		panic("keyed priority queue: key function cannot be nil")
+	}
+	return &KeyFuncPriorityQueue[T, V]{
+		keyFn: keyFn,
+		items: make(map[string]T),
+		pq:    NewKeyedPriorityQueue[string](cmp, WithoutLocking[string, V]()),
+	}
+}
+
+// Push inserts the given priority value v onto the priority queue associated with the given item t.
+// If the key of t already exists in the priority queue, it returns a KeyAlreadyExistsError error
+// holding the derived key.
+func (q *KeyFuncPriorityQueue[T, V]) Push(t T, v V) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	k := q.keyFn(t)
+	if err := q.pq.Push(k, v); err != nil {
+		return err
+	}
+	q.items[k] = t
+	return nil
+}
+
+// Set inserts a new entry in the priority queue with the given item t and value v,
+// if the key of t is not present in it; otherwise, it updates the priority value associated with it,
+// and replaces the stored item with t.
+func (q *KeyFuncPriorityQueue[T, V]) Set(t T, v V) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	k := q.keyFn(t)
+	q.pq.Set(k, v)
+	q.items[k] = t
+}
+
+// Update changes the priority value associated with the given item t to the given value v.
+// If the key of t doesn't exist in the priority queue, it returns a KeyNotFoundError error
+// holding the derived key.
+func (q *KeyFuncPriorityQueue[T, V]) Update(t T, v V) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.pq.Update(q.keyFn(t), v)
+}
+
+// Remove removes the priority value associated with the given item t from the priority queue.
+// It's a no-op if the key of t doesn't exist in the priority queue.
+func (q *KeyFuncPriorityQueue[T, V]) Remove(t T) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	k := q.keyFn(t)
+	q.pq.Remove(k)
+	delete(q.items, k)
+}
+
+// Pop removes and returns the highest priority item and value from the priority queue.
+// It returns false as its last return value if the priority queue is empty; otherwise, true.
+func (q *KeyFuncPriorityQueue[T, V]) Pop() (T, V, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	k, v, ok := q.pq.Pop()
+	if !ok {
+		var t T
+		return t, v, false
+	}
+	t := q.items[k]
+	delete(q.items, k)
+	return t, v, true
+}
+
+// Peek returns the highest priority item and value from the priority queue.
+// It returns false as its last return value if the priority queue is empty; otherwise, true.
+func (q *KeyFuncPriorityQueue[T, V]) Peek() (T, V, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	k, v, ok := q.pq.Peek()
+	return q.items[k], v, ok
+}
+
+// Contains returns true if the key of the given item t exists in the priority queue; otherwise, false.
+func (q *KeyFuncPriorityQueue[T, V]) Contains(t T) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return q.pq.Contains(q.keyFn(t))
+}
+
+// ValueOf returns the priority value associated with the given item t.
+// It returns false as its last return value if the key of t doesn't exist in the priority queue; otherwise, true.
+func (q *KeyFuncPriorityQueue[T, V]) ValueOf(t T) (V, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	return q.pq.ValueOf(q.keyFn(t))
+}
+
+// Len returns the size of the priority queue.
+func (q *KeyFuncPriorityQueue[T, V]) Len() int {
+	return q.pq.Len()
+}
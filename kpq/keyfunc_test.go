@@ -0,0 +1,98 @@
+package kpq
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type job struct {
+	path []string
+	note string
+}
+
+func jobKey(j job) string {
+	return strings.Join(j.path, "/")
+}
+
+func TestKeyFuncPriorityQueue(t *testing.T) {
+	pq := NewWithKeyFunc(jobKey, func(x, y int) bool {
+		return x < y
+	})
+
+	jobs := []struct {
+		job job
+		val int
+	}{
+		{job: job{path: []string{"a", "fourth"}}, val: 10},
+		{job: job{path: []string{"a", "second"}}, val: 8},
+		{job: job{path: []string{"b", "third"}}, val: 9},
+		{job: job{path: []string{"b", "first"}}, val: 6},
+		{job: job{path: []string{"c", "last"}}, val: 20},
+	}
+
+	for _, j := range jobs {
+		if err := pq.Push(j.job, j.val); err != nil {
+			t.Fatalf("pq.Push(%v, %d): got unexpected error %v", j.job, j.val, err)
+		}
+	}
+
+	dup := job{path: []string{"a", "second"}, note: "same key"}
+	err := pq.Push(dup, 1)
+	var wantErr KeyAlreadyExistsError[string]
+	if !errors.As(err, &wantErr) || wantErr.Key() != "a/second" {
+		t.Errorf("pq.Push(%v, 1): got error %v; want KeyAlreadyExistsError for %q", dup, err, "a/second")
+	}
+
+	if !pq.Contains(dup) {
+		t.Errorf("pq.Contains(%v): got false; want true", dup)
+	}
+	if v, ok := pq.ValueOf(dup); !ok || v != 8 {
+		t.Errorf("pq.ValueOf(%v): got (%d, %t); want (%d, %t)", dup, v, ok, 8, true)
+	}
+
+	if err := pq.Update(job{path: []string{"c", "last"}}, 7); err != nil {
+		t.Errorf("pq.Update(): got unexpected error %v", err)
+	}
+	if err := pq.Update(job{path: []string{"missing"}}, 7); err == nil {
+		t.Error("pq.Update(): got nil error for a missing key; want KeyNotFoundError")
+	}
+
+	pq.Set(dup, 5)
+	pq.Remove(job{path: []string{"b", "third"}})
+
+	if got, want := pq.Len(), 4; got != want {
+		t.Errorf("pq.Len(): got %d; want %d", got, want)
+	}
+	if j, v, ok := pq.Peek(); !ok || jobKey(j) != "a/second" || j.note != "same key" || v != 5 {
+		t.Errorf("pq.Peek(): got (%v, %d, %t); want (%v, %d, %t)", j, v, ok, dup, 5, true)
+	}
+
+	want := []string{"a/second", "b/first", "c/last", "a/fourth"}
+	for _, w := range want {
+		j, _, ok := pq.Pop()
+		if !ok || jobKey(j) != w {
+			t.Errorf("pq.Pop(): got (%v, %t); want key %q", j, ok, w)
+		}
+	}
+	if _, _, ok := pq.Pop(); ok {
+		t.Error("pq.Pop(): got true on empty priority queue; want false")
+	}
+}
+
+func TestNewWithKeyFunc_Nil(t *testing.T) {
+	for name, fn := range map[string]func(){
+		"KeyFunc": func() { NewWithKeyFunc[job](nil, func(x, y int) bool { return x < y }) },
+		"Cmp":     func() { NewWithKeyFunc[job, int](jobKey, nil) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err == nil {
+					t.Errorf("want NewWithKeyFunc to panic with a nil %s", name)
+				}
+			}()
+
+			fn()
+		})
+	}
+}
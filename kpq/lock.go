@@ -0,0 +1,27 @@
+package kpq
+
+import "sync"
+
+// rwLocker is the locking behavior required by a KeyedPriorityQueue.
+// It's satisfied by *sync.RWMutex.
+type rwLocker interface {
+	sync.Locker
+	RLock()
+	RUnlock()
+}
+
+// noLock is a rwLocker whose methods do nothing.
+type noLock struct{}
+
+func (noLock) Lock()    {}
+func (noLock) Unlock()  {}
+func (noLock) RLock()   {}
+func (noLock) RUnlock() {}
+
+// exclusiveLocker is a rwLocker that takes the full lock of a sync.Locker for reading too.
+type exclusiveLocker struct {
+	sync.Locker
+}
+
+func (l exclusiveLocker) RLock()   { l.Lock() }
+func (l exclusiveLocker) RUnlock() { l.Unlock() }
@@ -0,0 +1,357 @@
+package kpq
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// MinMaxKeyedPriorityQueue represents a generic double-ended keyed priority queue,
+// where K is the key type and V is the priority value type.
+//
+// Unlike KeyedPriorityQueue, it gives efficient access to both ends of the priority queue:
+// the highest priority entry (the min, i.e., the first according to the comparison function)
+// and the lowest priority entry (the max, i.e., the last according to the comparison function).
+// It leverages a min-max heap, where the entries at even levels of the heap compare before their descendants
+// and the entries at odd levels compare after their descendants.
+// Operations like Push, PopMin and PopMax have O(log n) time complexity, where n is the size of the priority queue.
+// Operations like PeekMin, PeekMax, Contains and ValueOf have O(1) time complexity.
+//
+// MinMaxKeyedPriorityQueue is safe for concurrent use.
+//
+// MinMaxKeyedPriorityQueue must not be copied after first use.
+type MinMaxKeyedPriorityQueue[K comparable, V any] struct {
+	mu sync.RWMutex
+
+	pm   []K       // position map
+	im   map[K]int // inverse map of pm; note that for a given key k, pm[im[k]] == k
+	vals map[K]V   // generic priority values of key k
+	cmp  CmpFunc[V]
+}
+
+// NewMinMaxKeyedPriorityQueue returns a new double-ended keyed priority queue
+// that uses the given cmp function for ordering the priority queue.
+//
+// NewMinMaxKeyedPriorityQueue will panic if cmp is nil.
+func NewMinMaxKeyedPriorityQueue[K comparable, V any](cmp CmpFunc[V]) *MinMaxKeyedPriorityQueue[K, V] {
+	if cmp == nil {
+		panic("keyed priority queue: comparison function cannot be nil")
+	}
+	return &MinMaxKeyedPriorityQueue[K, V]{
+		pm:   make([]K, 0),
+		im:   make(map[K]int),
+		vals: make(map[K]V),
+		cmp:  cmp,
+	}
+}
+
+// Push inserts the given priority value v onto the priority queue associated with the given key k.
+// If the key already exists in the priority queue, it returns a KeyAlreadyExistsError error.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) Push(k K, v V) error {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	if _, ok := pq.im[k]; ok {
+		return newKeyAlreadyExistsError(k)
+	}
+
+	n := len(pq.pm)
+	pq.pm = append(pq.pm, k)
+	pq.im[k] = n
+	pq.vals[k] = v
+	pq.up(n)
+	return nil
+}
+
+// Set inserts a new entry in the priority queue with the given key and value,
+// if the key is not present in it; otherwise, it updates the priority value associated with the given key.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) Set(k K, v V) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	pq.vals[k] = v
+	if i, ok := pq.im[k]; ok {
+		pq.fix(i)
+		return
+	}
+
+	n := len(pq.pm)
+	pq.pm = append(pq.pm, k)
+	pq.im[k] = n
+	pq.up(n)
+}
+
+// Update changes the priority value associated with the given key k to the given value v,
+// moving k towards either end of the priority queue as needed.
+// If there's no key k in the priority queue, it returns a KeyNotFoundError error.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) Update(k K, v V) error {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	i, ok := pq.im[k]
+	if !ok {
+		return newKeyNotFoundError(k)
+	}
+
+	pq.vals[k] = v
+	pq.fix(i)
+	return nil
+}
+
+// Remove removes the priority value associated with the given key k from the priority queue,
+// regardless of its position.
+// It's a no-op if there's no such key k in the priority queue.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) Remove(k K) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	if i, ok := pq.im[k]; ok {
+		pq.removeAt(i)
+	}
+}
+
+// PopMin removes and returns the highest priority key and value from the priority queue.
+// It returns false as its last return value if the priority queue is empty; otherwise, true.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) PopMin() (K, V, bool) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	if len(pq.pm) == 0 {
+		var k K
+		var v V
+		return k, v, false
+	}
+	k, v := pq.removeAt(0)
+	return k, v, true
+}
+
+// PopMax removes and returns the lowest priority key and value from the priority queue.
+// It returns false as its last return value if the priority queue is empty; otherwise, true.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) PopMax() (K, V, bool) {
+	pq.mu.Lock()
+	defer pq.mu.Unlock()
+
+	if len(pq.pm) == 0 {
+		var k K
+		var v V
+		return k, v, false
+	}
+	k, v := pq.removeAt(pq.max())
+	return k, v, true
+}
+
+// PeekMin returns the highest priority key and value from the priority queue.
+// It returns false as its last return value if the priority queue is empty; otherwise, true.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) PeekMin() (K, V, bool) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	if len(pq.pm) == 0 {
+		var k K
+		var v V
+		return k, v, false
+	}
+	return pq.pm[0], pq.vals[pq.pm[0]], true
+}
+
+// PeekMax returns the lowest priority key and value from the priority queue.
+// It returns false as its last return value if the priority queue is empty; otherwise, true.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) PeekMax() (K, V, bool) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	if len(pq.pm) == 0 {
+		var k K
+		var v V
+		return k, v, false
+	}
+	k := pq.pm[pq.max()]
+	return k, pq.vals[k], true
+}
+
+// Contains returns true if the given key k exists in the priority queue; otherwise, false.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) Contains(k K) bool {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	_, ok := pq.im[k]
+	return ok
+}
+
+// ValueOf returns the priority value associated with the given key k.
+// It returns false as its last return value if there's no such key k
+// in the priority queue; otherwise, true.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) ValueOf(k K) (V, bool) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	v, ok := pq.vals[k]
+	return v, ok
+}
+
+// Len returns the size of the priority queue.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) Len() int {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	return len(pq.pm)
+}
+
+// IsEmpty returns true if the priority queue is empty; otherwise, false.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) IsEmpty() bool {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	return len(pq.pm) == 0
+}
+
+// max returns the position of the lowest priority entry of the heap, which must not be empty.
+// It's the root, if it's the only entry, or one of its children.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) max() int {
+	switch len(pq.pm) {
+	case 1:
+		return 0
+	case 2:
+		return 1
+	}
+	if pq.less(1, 2) {
+		return 2
+	}
+	return 1
+}
+
+// removeAt removes the entry at the position i of the heap and returns its key and value.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) removeAt(i int) (K, V) {
+	n := len(pq.pm) - 1
+	k := pq.pm[i]
+	v := pq.vals[k]
+	pq.swap(i, n)
+	pq.pm = pq.pm[:n]
+	delete(pq.im, k)
+	delete(pq.vals, k)
+	if i != n {
+		pq.fix(i)
+	}
+	return k, v
+}
+
+// fix restores the heap ordering after the entry at the position i changed.
+// If the entry moves up, the one taking its place comes from an ancestor and is moved down;
+// otherwise, the entry itself may need to move down.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) fix(i int) {
+	pq.up(i)
+	pq.down(i)
+}
+
+func (pq *MinMaxKeyedPriorityQueue[K, V]) up(i int) {
+	if i == 0 {
+		return
+	}
+	p := parent(i, 2)
+	if isMinLevel(i) {
+		if pq.less(p, i) {
+			pq.swap(i, p)
+			pq.upMax(p)
+			return
+		}
+		pq.upMin(i)
+		return
+	}
+	if pq.less(i, p) {
+		pq.swap(i, p)
+		pq.upMin(p)
+		return
+	}
+	pq.upMax(i)
+}
+
+func (pq *MinMaxKeyedPriorityQueue[K, V]) upMin(i int) {
+	for i > 2 { // i has a grandparent
+		g := parent(parent(i, 2), 2)
+		if !pq.less(i, g) {
+			return
+		}
+		pq.swap(i, g)
+		i = g
+	}
+}
+
+func (pq *MinMaxKeyedPriorityQueue[K, V]) upMax(i int) {
+	for i > 2 { // i has a grandparent
+		g := parent(parent(i, 2), 2)
+		if !pq.less(g, i) {
+			return
+		}
+		pq.swap(i, g)
+		i = g
+	}
+}
+
+func (pq *MinMaxKeyedPriorityQueue[K, V]) down(i int) {
+	if isMinLevel(i) {
+		pq.downFunc(i, pq.less)
+		return
+	}
+	pq.downFunc(i, pq.greater)
+}
+
+// downFunc moves the entry at the position i down the heap, where before defines the ordering of
+// the level of i: less for min levels; greater for max levels.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) downFunc(i int, before func(i, j int) bool) {
+	for {
+		m, grandchild := pq.first(i, before)
+		if m < 0 || !before(m, i) {
+			return
+		}
+		pq.swap(m, i)
+		if !grandchild {
+			return
+		}
+		if p := parent(m, 2); before(p, m) {
+			pq.swap(m, p)
+		}
+		i = m
+	}
+}
+
+// first returns the position of the first entry per before among the children and grandchildren of i,
+// and whether it's a grandchild. It returns -1 if i has no children.
+func (pq *MinMaxKeyedPriorityQueue[K, V]) first(i int, before func(i, j int) bool) (int, bool) {
+	n := len(pq.pm)
+	if !hasChild(i, n, 2) {
+		return -1, false
+	}
+	c := leftChild(i, 2)
+	m, grandchild := c, false
+	if c+1 < n && before(c+1, m) {
+		m = c + 1
+	}
+	if !hasChild(c, n, 2) {
+		return m, grandchild
+	}
+	// the grandchildren of i are the children of c and c+1, which are contiguous.
+	g := leftChild(c, 2)
+	for j := g; j < n && j-g < 4; j++ {
+		if before(j, m) {
+			m, grandchild = j, true
+		}
+	}
+	return m, grandchild
+}
+
+func (pq *MinMaxKeyedPriorityQueue[K, V]) swap(i, j int) {
+	pq.pm[i], pq.pm[j] = pq.pm[j], pq.pm[i]
+	pq.im[pq.pm[i]], pq.im[pq.pm[j]] = i, j
+}
+
+func (pq *MinMaxKeyedPriorityQueue[K, V]) less(i, j int) bool {
+	return pq.cmp(pq.vals[pq.pm[i]], pq.vals[pq.pm[j]])
+}
+
+func (pq *MinMaxKeyedPriorityQueue[K, V]) greater(i, j int) bool {
+	return pq.less(j, i)
+}
+
+// isMinLevel reports whether the position i is at an even level of the heap, where the root is at level 0.
+func isMinLevel(i int) bool {
+	return bits.Len(uint(i)+1)%2 == 1
+}
@@ -0,0 +1,188 @@
+package kpq
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestNewMinMaxKeyedPriorityQueue_NilCmp(t *testing.T) {
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("want NewMinMaxKeyedPriorityQueue to panic when receiving a nil comparison function")
+		}
+	}()
+
+	NewMinMaxKeyedPriorityQueue[int, int](nil)
+}
+
+func TestMinMaxKeyedPriorityQueue_Push(t *testing.T) {
+	pq := NewMinMaxKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	if gotKey, gotVal, _ := pq.PeekMin(); gotKey != "first" || gotVal != 6 {
+		t.Errorf("pq.PeekMin(): got (%q, %d); want (%q, %d)", gotKey, gotVal, "first", 6)
+	}
+
+	if gotKey, gotVal, _ := pq.PeekMax(); gotKey != "last" || gotVal != 20 {
+		t.Errorf("pq.PeekMax(): got (%q, %d); want (%q, %d)", gotKey, gotVal, "last", 20)
+	}
+
+	if got, want := pq.Len(), len(items); got != want {
+		t.Errorf("pq.Len(): got %d; want %d", got, want)
+	}
+
+	if !pq.Contains("third") {
+		t.Errorf("pq.Contains(%q): got no key in priority queue", "third")
+	}
+
+	if got, _ := pq.ValueOf("third"); got != 9 {
+		t.Errorf("pq.ValueOf(%q): got %d; want %d", "third", got, 9)
+	}
+
+	t.Run("KeyAlreadyExists", func(t *testing.T) {
+		err := pq.Push("first", 1)
+
+		var wantErr KeyAlreadyExistsError[string]
+		if !errors.As(err, &wantErr) {
+			t.Errorf("pq.Push(%q, 1): got error type %T; want it to be %T", "first", err, wantErr)
+		}
+	})
+}
+
+func TestMinMaxKeyedPriorityQueue_PopMinMax(t *testing.T) {
+	pq := NewMinMaxKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	})
+
+	r := rand.New(rand.NewSource(1))
+	n := 500
+	want := make([]int, n)
+	for k := 0; k < n; k++ {
+		want[k] = r.Intn(1000)
+		if err := pq.Push(k, want[k]); err != nil {
+			t.Fatalf("pq.Push(%d, %d): got unexpected error %v", k, want[k], err)
+		}
+	}
+	sort.Ints(want)
+
+	lo, hi := 0, n-1
+	for !pq.IsEmpty() {
+		if r.Intn(2) == 0 {
+			_, got, _ := pq.PopMin()
+			if got != want[lo] {
+				t.Fatalf("pq.PopMin(): got value %d; want %d", got, want[lo])
+			}
+			lo++
+		} else {
+			_, got, _ := pq.PopMax()
+			if got != want[hi] {
+				t.Fatalf("pq.PopMax(): got value %d; want %d", got, want[hi])
+			}
+			hi--
+		}
+	}
+}
+
+func TestMinMaxKeyedPriorityQueue_UpdateRemove(t *testing.T) {
+	pq := NewMinMaxKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	})
+
+	r := rand.New(rand.NewSource(1))
+	n := 300
+	vals := make(map[int]int, n)
+	for k := 0; k < n; k++ {
+		vals[k] = r.Intn(1000)
+		pq.Set(k, vals[k])
+	}
+
+	for i := 0; i < 500; i++ {
+		k := r.Intn(n)
+		switch r.Intn(3) {
+		case 0:
+			v := r.Intn(1000)
+			if _, ok := vals[k]; !ok {
+				if err := pq.Update(k, v); err == nil {
+					t.Fatalf("pq.Update(%d, %d): got nil error for removed key", k, v)
+				}
+				continue
+			}
+			if err := pq.Update(k, v); err != nil {
+				t.Fatalf("pq.Update(%d, %d): got unexpected error %v", k, v, err)
+			}
+			vals[k] = v
+		case 1:
+			v := r.Intn(1000)
+			pq.Set(k, v)
+			vals[k] = v
+		default:
+			pq.Remove(k)
+			delete(vals, k)
+		}
+	}
+
+	if got, want := pq.Len(), len(vals); got != want {
+		t.Fatalf("pq.Len(): got %d; want %d", got, want)
+	}
+
+	want := make([]int, 0, len(vals))
+	for _, v := range vals {
+		want = append(want, v)
+	}
+	sort.Ints(want)
+
+	lo, hi := 0, len(want)-1
+	for !pq.IsEmpty() {
+		if r.Intn(2) == 0 {
+			k, got, _ := pq.PopMin()
+			if got != want[lo] || vals[k] != got {
+				t.Fatalf("pq.PopMin(): got (%d, %d); want value %d", k, got, want[lo])
+			}
+			lo++
+		} else {
+			k, got, _ := pq.PopMax()
+			if got != want[hi] || vals[k] != got {
+				t.Fatalf("pq.PopMax(): got (%d, %d); want value %d", k, got, want[hi])
+			}
+			hi--
+		}
+	}
+}
+
+func TestMinMaxKeyedPriorityQueue_EmptyQueue(t *testing.T) {
+	pq := NewMinMaxKeyedPriorityQueue[int](func(x, y int) bool { return x < y })
+
+	if _, _, ok := pq.PeekMin(); ok {
+		t.Error("pq.PeekMin(): got unexpected non empty priority queue")
+	}
+	if _, _, ok := pq.PeekMax(); ok {
+		t.Error("pq.PeekMax(): got unexpected non empty priority queue")
+	}
+	if _, _, ok := pq.PopMin(); ok {
+		t.Error("pq.PopMin(): got unexpected non empty priority queue")
+	}
+	if _, _, ok := pq.PopMax(); ok {
+		t.Error("pq.PopMax(): got unexpected non empty priority queue")
+	}
+}
@@ -0,0 +1,371 @@
+package kpq
+
+import (
+	"cmp"
+	"fmt"
+	"sync"
+)
+
+// Option configures a KeyedPriorityQueue on construction.
+type Option[K comparable, V any] func(*KeyedPriorityQueue[K, V])
+
+// WithoutLocking returns an Option that disables the internal locking of the priority queue,
+// removing the locking overhead from every operation.
+//
+// A priority queue created with this option is NOT safe for concurrent use:
+// all of its methods must be called from a single goroutine or be synchronized by the caller.
+// Concurrent access to such a priority queue corrupts its internal state.
+func WithoutLocking[K comparable, V any]() Option[K, V] {
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.mu = noLock{}
+	}
+}
+
+// WithLocker returns an Option that makes the priority queue use l for locking, instead of its own sync.RWMutex.
+// It allows sharing a lock across several related structures, or using a different lock implementation.
+//
+// If l also provides the RLock and RUnlock methods, like *sync.RWMutex does,
+// the read-only methods of the priority queue take the read lock, allowing them to run concurrently.
+// Otherwise, the distinction between readers and writers is lost:
+// read-only methods take the full lock, so they're serialized with every other method.
+//
+// The priority queue holds l only for the duration of each of its methods and never reentrantly,
+// so calling its methods while holding l deadlocks.
+//
+// It panics if l is nil.
+func WithLocker[K comparable, V any](l sync.Locker) Option[K, V] {
+	if l == nil {
+		panic("keyed priority queue: nil locker")
+	}
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		if rw, ok := l.(rwLocker); ok {
+			pq.mu = rw
+			return
+		}
+		pq.mu = exclusiveLocker{l}
+	}
+}
+
+// WithAutoShrink returns an Option that makes the priority queue release memory automatically
+// after being drained: when a Pop or Remove leaves the priority queue with fewer entries than
+// threshold times its capacity, the backing slice is reallocated to headroom times the current size.
+//
+// Together, threshold and headroom work as a hysteresis that prevents the priority queue
+// from shrinking and growing repeatedly: the lower the threshold, the less often it shrinks,
+// and the greater the headroom, the more entries can be pushed after shrinking before it grows again.
+// Only the backing slice is shrunk; see TrimToSize for releasing the memory held by the maps.
+//
+// WithAutoShrink will panic if threshold is not within the open interval (0, 1),
+// if headroom is not greater than 1 or if threshold times headroom is not less than 1,
+// which would make the priority queue shrink again right after shrinking.
+func WithAutoShrink[K comparable, V any](threshold, headroom float64) Option[K, V] {
+	if threshold <= 0 || threshold >= 1 {
+		panic("keyed priority queue: auto shrink threshold must be within (0, 1)")
+	}
+	if headroom <= 1 {
+		panic("keyed priority queue: auto shrink headroom must be greater than 1")
+	}
+	if threshold*headroom >= 1 {
+		panic("keyed priority queue: auto shrink threshold times headroom must be less than 1")
+	}
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.shrinkAt = threshold
+		pq.headroom = headroom
+	}
+}
+
+// WithGrowthFactor returns an Option that makes the backing slice of the priority queue grow by the factor f
+// whenever it's full, instead of following the growth strategy of append.
+// A factor close to 1 keeps the peak memory low at the cost of more frequent reallocations,
+// while a larger factor reallocates less often but may leave more capacity unused.
+// The backing slice always grows by at least one entry.
+//
+// WithGrowthFactor will panic if f is not greater than 1.
+func WithGrowthFactor[K comparable, V any](f float64) Option[K, V] {
+	if !(f > 1) {
+		panic("keyed priority queue: growth factor must be greater than 1")
+	}
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.growth = f
+	}
+}
+
+// WithCapacity returns an Option that preallocates the priority queue to hold n entries
+// without reallocating its internal structures.
+//
+// WithCapacity will panic if n is negative.
+func WithCapacity[K comparable, V any](n int) Option[K, V] {
+	if n < 0 {
+		panic("keyed priority queue: capacity cannot be negative")
+	}
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.realloc(n, true)
+	}
+}
+
+// StablePolicy describes how a priority queue created with the WithStableOrdering option
+// orders an updated key among the entries with the same priority.
+type StablePolicy int
+
+const (
+	// KeepOriginalSequence means an updated key keeps its original insertion order,
+	// so it's popped before the entries with the same priority pushed after it.
+	KeepOriginalSequence StablePolicy = iota
+	// RefreshSequence means an updated key counts as newly inserted,
+	// so it's popped after all the entries with the same priority.
+	RefreshSequence
+)
+
+// WithStableOrdering returns an Option that makes the priority queue break ties by insertion order:
+// among entries with the same priority, the ones pushed first are popped first.
+// The optional policy tells whether updating the priority value of a key, e.g., with Update or Set,
+// keeps its original insertion order, which is the default, or refreshes it.
+// DecreaseKey, IncreaseKey, SwapPriorities and ReplaceValues always keep it.
+//
+// Stable ordering costs an extra map entry per key and up to two calls of the comparison function
+// for each comparison between entries, or a single one for priority queues created with NewWithCompare.
+//
+// WithStableOrdering will panic if more than one policy is given, or if it's not a known policy.
+func WithStableOrdering[K comparable, V any](policy ...StablePolicy) Option[K, V] {
+	if len(policy) > 1 {
+		panic("keyed priority queue: at most one stable ordering policy can be given")
+	}
+	refresh := len(policy) == 1 && policy[0] == RefreshSequence
+	if len(policy) == 1 && !refresh && policy[0] != KeepOriginalSequence {
+		panic(fmt.Sprintf("keyed priority queue: unknown stable ordering policy %d", int(policy[0])))
+	}
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.seq = make(map[K]uint64, cap(pq.pm))
+		pq.refresh = refresh
+	}
+}
+
+// WithMaxSize returns an Option that bounds the priority queue to at most n entries.
+//
+// If evict is true, pushing a new key onto a full priority queue evicts its lowest priority entry
+// to make room for the new one, or discards the new entry if it doesn't have a higher priority
+// than the evicted one would have. Either way, the insertion doesn't return an error.
+// Finding the lowest priority entry takes O(n) time, as it scans the leaves of the heap.
+//
+// If evict is false, pushing a new key onto a full priority queue fails instead,
+// with Push returning a CapacityExceededError error, which suits applying backpressure.
+//
+// WithMaxSize will panic if n is not positive.
+func WithMaxSize[K comparable, V any](n int, evict bool) Option[K, V] {
+	if n <= 0 {
+		panic("keyed priority queue: max size must be positive")
+	}
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.maxSize = n
+		pq.reject = !evict
+	}
+}
+
+// WithUpsertPush returns an Option that makes Push update the priority value of an existing key,
+// like Set does, instead of returning a KeyAlreadyExistsError error.
+// Consequently, MustPush never panics either. Offer is unaffected and never updates existing keys.
+// It suits codebases that use Push everywhere with "last write wins" semantics.
+func WithUpsertPush[K comparable, V any]() Option[K, V] {
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.upsert = true
+	}
+}
+
+// WithoutDuplicateCheck returns an Option that makes Push skip checking whether the key already exists
+// in the priority queue, saving a map lookup per push, e.g., for bulk loading keys known to be unique.
+// It also makes Push ignore the WithUpsertPush option. Offer and Set still check for existing keys.
+//
+// WARNING: pushing a key that's already in the priority queue isn't detected,
+// and corrupts its internal structures, which makes the behavior of the priority queue undefined.
+// Only use this option for trusted input whose keys are guaranteed to be unique.
+func WithoutDuplicateCheck[K comparable, V any]() Option[K, V] {
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.trusted = true
+	}
+}
+
+// WithComparatorValidation returns an Option that makes bulk constructors, such as NewFromItems,
+// spot-check that the comparison function is a strict ordering of the given values,
+// panicking with a descriptive message otherwise. It catches mistakes like using <= instead of <,
+// which silently break the heap ordering.
+//
+// It's a development aid: the check costs O(n) extra calls of the comparison function on construction,
+// and it can't prove the comparison function right. Pushing entries afterwards isn't checked.
+func WithComparatorValidation[K comparable, V any]() Option[K, V] {
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.validate = true
+	}
+}
+
+// WithMonotoneIncreasing returns an Option for workloads where updates never raise the priority of a key,
+// e.g., a min priority queue of timestamps that only move forward.
+// Update, Set and the other methods updating existing keys then only move them down the heap,
+// skipping the comparison that decides between moving them up or down.
+// DecreaseKey, IncreaseKey and SwapPriorities are unaffected.
+//
+// Updating a key with a higher priority value than its current one isn't detected,
+// and leaves the heap ordering broken, which makes the behavior of the priority queue undefined.
+func WithMonotoneIncreasing[K comparable, V any]() Option[K, V] {
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.monotone = true
+	}
+}
+
+// WithComparatorCaching returns an Option for priority queues whose comparison function is expensive,
+// e.g., because it parses its arguments. The given key function derives a sort key from a priority value,
+// which is computed once whenever the priority value of a key is set, and cached along with it.
+// Sifting entries up and down the heap then compares the cached sort keys, in ascending order,
+// instead of calling the comparison function over and over on the same unchanged values.
+//
+// Ordering by the sort keys must be consistent with the comparison function, i.e., cmp(x, y) must be true
+// if and only if key(x) < key(y), since the comparison function is still called by a few operations.
+//
+// Caching costs a sort key per entry of the priority queue, stored alongside its priority value
+// and moved along with it, so it only pays off when calling the comparison function dominates the cost of the operations.
+// It also makes Swap take O(n) time, as the cached sort keys of the swapped entries are recomputed.
+//
+// WithComparatorCaching will panic if key is nil.
+func WithComparatorCaching[K comparable, V any, S cmp.Ordered](key func(v V) S) Option[K, V] {
+	if key == nil {
+		panic("keyed priority queue: sort key function cannot be nil")
+	}
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.keys = newSortKeys(key, cap(pq.pm))
+		pq.rekey(cap(pq.pm))
+	}
+}
+
+// WithValueCopier returns an Option that makes the lookup methods return copy(v) instead of the stored priority value v,
+// i.e., Peek, PeekWithLen, PeekItem, PeekValue, ValueOf, ValueOfMany, GetOr and WaitForTop,
+// as well as the facade passed to the function given to View and the view returned by Snapshot.
+// It suits priority values that are slices or contain pointers, which callers could otherwise mutate,
+// silently breaking the heap ordering. The other methods, e.g., Pop or the iterators, return the stored values.
+// Without this option, the stored priority values are returned as is.
+//
+// copy is called while holding the priority queue lock, so it must not call any of its methods.
+// WithValueCopier will panic if copy is nil.
+func WithValueCopier[K comparable, V any](copy func(v V) V) Option[K, V] {
+	if copy == nil {
+		panic("keyed priority queue: value copier cannot be nil")
+	}
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.copier = copy
+	}
+}
+
+// WithArity returns an Option that makes the priority queue leverage a d-ary heap instead of a binary one,
+// where each entry has up to d children.
+// A higher arity makes the heap shallower, reducing the cost of Push and Update and improving the cache
+// locality of large priority queues, at the cost of more comparisons per level on Pop and Remove.
+// The default arity is 2.
+//
+// WithArity will panic if d is less than 2.
+func WithArity[K comparable, V any](d int) Option[K, V] {
+	if d < 2 {
+		panic("keyed priority queue: arity must be at least 2")
+	}
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.d = d
+	}
+}
+
+// WithLazyDeletion returns an Option that makes Remove mark keys as removed instead of
+// restructuring the heap, which makes removing entries other than the highest priority one O(1).
+// Removed keys are accounted for by Len, Contains and ValueOf right away, while their entries,
+// called tombstones, are skipped by Pop once they reach the top of the heap.
+//
+// It trades memory for time: tombstones take up space until they're discarded.
+// Whenever the number of tombstones exceeds the given fraction of the size of the priority queue,
+// the heap is compacted in O(n) time.
+//
+// WithLazyDeletion will panic if fraction is not positive.
+func WithLazyDeletion[K comparable, V any](fraction float64) Option[K, V] {
+	if fraction <= 0 {
+		panic("keyed priority queue: lazy deletion fraction must be positive")
+	}
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.dead = make(map[K]struct{})
+		pq.deadRatio = fraction
+	}
+}
+
+// EvictReason describes why an entry left a priority queue created with the WithOnEvict option.
+type EvictReason int
+
+const (
+	// EvictPop means the entry was popped, e.g., by Pop or one of its variants.
+	EvictPop EvictReason = iota
+	// EvictRemove means the entry was removed by Remove.
+	EvictRemove
+	// EvictCapacity means the entry was evicted to honor the WithMaxSize bound,
+	// or discarded on insertion because the priority queue was full, e.g., of higher priority entries.
+	EvictCapacity
+)
+
+// String returns the name of the eviction reason.
+func (r EvictReason) String() string {
+	switch r {
+	case EvictPop:
+		return "pop"
+	case EvictRemove:
+		return "remove"
+	case EvictCapacity:
+		return "capacity"
+	}
+	return fmt.Sprintf("EvictReason(%d)", int(r))
+}
+
+// eviction is an eviction pending to be reported to the eviction callback.
+type eviction[K comparable, V any] struct {
+	k      K
+	v      V
+	reason EvictReason
+}
+
+// WithOnEvict returns an Option that makes the priority queue call fn whenever an entry leaves it,
+// with the key and value of the entry and the reason why it left.
+// It centralizes cleanup logic, such as releasing resources tied to the entries.
+//
+// fn is called after the priority queue lock is released, so it may call methods of the priority queue.
+// Consequently, other goroutines may mutate the priority queue before fn is called.
+// When a single operation evicts several entries, fn is called for each of them in order,
+// from the goroutine that performed the operation.
+func WithOnEvict[K comparable, V any](fn func(k K, v V, reason EvictReason)) Option[K, V] {
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.onEvict = fn
+	}
+}
+
+// Metrics receives the events of a priority queue created with the WithMetrics option,
+// e.g., for exporting them as counters and histograms to a monitoring system.
+//
+// Its methods are called while holding the priority queue lock, so they must be fast,
+// and they must not call methods of the priority queue.
+type Metrics interface {
+	// IncPush is called for each new key inserted onto the priority queue.
+	IncPush()
+	// IncPop is called for each entry popped from the priority queue.
+	IncPop()
+	// IncUpdate is called for each change of the priority value of an existing key.
+	IncUpdate()
+	// IncRemove is called for each entry removed from the priority queue by key, e.g., by Remove.
+	IncRemove()
+	// IncEvict is called for each entry evicted or discarded to honor the WithMaxSize bound.
+	IncEvict()
+	// ObserveSiftDepth is called for each sift of an entry up or down the heap,
+	// including those of rebuilding the heap, with the number of levels the entry moved.
+	ObserveSiftDepth(depth int)
+}
+
+// WithMetrics returns an Option that makes the priority queue report its operations to m.
+// Without this option, collecting metrics costs a nil check per event.
+//
+// WithMetrics will panic if m is nil.
+func WithMetrics[K comparable, V any](m Metrics) Option[K, V] {
+	if m == nil {
+		panic("keyed priority queue: nil metrics")
+	}
+	return func(pq *KeyedPriorityQueue[K, V]) {
+		pq.metrics = m
+	}
+}
@@ -0,0 +1,1168 @@
+package kpq
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestWithoutLocking(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithoutLocking[string, int]())
+
+	if _, ok := pq.mu.(noLock); !ok {
+		t.Fatalf("pq.mu: got locker of type %T; want %T", pq.mu, noLock{})
+	}
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	if err := pq.Update("last", 7); err != nil {
+		t.Fatalf("pq.Update(%q, 7): got unexpected error %v", "last", err)
+	}
+	pq.Remove("third")
+
+	wantKeys := []string{"first", "last", "second", "fourth"}
+	for _, want := range wantKeys {
+		got, _, ok := pq.Pop()
+		if !ok {
+			t.Fatal("pq.Pop(): got unexpected empty priority queue")
+		}
+		if got != want {
+			t.Errorf("pq.Pop(): got key %q; want %q", got, want)
+		}
+	}
+
+	if !pq.IsEmpty() {
+		t.Errorf("pq.IsEmpty(): got unexpected non-empty priority queue")
+	}
+}
+
+type countingLocker struct {
+	mu            sync.Mutex
+	locks, rlocks int
+}
+
+func (l *countingLocker) Lock()   { l.mu.Lock(); l.locks++ }
+func (l *countingLocker) Unlock() { l.mu.Unlock() }
+
+type countingRWLocker struct {
+	countingLocker
+}
+
+func (l *countingRWLocker) RLock()   { l.mu.Lock(); l.rlocks++ }
+func (l *countingRWLocker) RUnlock() { l.mu.Unlock() }
+
+func TestWithLocker(t *testing.T) {
+	t.Run("Locker", func(t *testing.T) {
+		var l countingLocker
+		pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+			return x < y
+		}, WithLocker[string, int](&l))
+
+		pq.Push("first", 1)
+		pq.Peek()
+		pq.Contains("first")
+
+		if got, want := l.locks, 3; got != want {
+			t.Errorf("l.locks: got %d; want %d", got, want)
+		}
+	})
+
+	t.Run("RWLocker", func(t *testing.T) {
+		var l countingRWLocker
+		pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+			return x < y
+		}, WithLocker[string, int](&l))
+
+		pq.Push("first", 1)
+		pq.Peek()
+		pq.Contains("first")
+
+		if got, want := l.locks, 1; got != want {
+			t.Errorf("l.locks: got %d; want %d", got, want)
+		}
+		if got, want := l.rlocks, 2; got != want {
+			t.Errorf("l.rlocks: got %d; want %d", got, want)
+		}
+	})
+
+	t.Run("Shared", func(t *testing.T) {
+		var mu sync.Mutex
+		pq1 := NewKeyedPriorityQueue[int](func(x, y int) bool {
+			return x < y
+		}, WithLocker[int, int](&mu))
+		pq2 := NewKeyedPriorityQueue[int](func(x, y int) bool {
+			return x < y
+		}, WithLocker[int, int](&mu))
+
+		var wg sync.WaitGroup
+		for _, pq := range []*KeyedPriorityQueue[int, int]{pq1, pq2} {
+			wg.Add(1)
+			go func(pq *KeyedPriorityQueue[int, int]) {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					pq.Push(i, i)
+					pq.Peek()
+				}
+			}(pq)
+		}
+		wg.Wait()
+
+		if pq1.Len() != 100 || pq2.Len() != 100 {
+			t.Errorf("Len(): got %d and %d; want 100 and 100", pq1.Len(), pq2.Len())
+		}
+	})
+}
+
+func TestWithLocker_NilLocker(t *testing.T) {
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("want WithLocker(nil) to panic")
+		}
+	}()
+	WithLocker[string, int](nil)
+}
+
+func TestWithAutoShrink(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	}, WithAutoShrink[int, int](0.25, 2))
+
+	n := 1000
+	for k := 0; k < n; k++ {
+		pq.Push(k, k)
+	}
+	peak := pq.Cap()
+
+	for k := 0; k < n-10; k++ {
+		if k%2 == 0 {
+			pq.Pop()
+		} else {
+			pq.Remove(n - k)
+		}
+		if c, l := pq.Cap(), pq.Len(); c > minShrinkCap && float64(l) < 0.25*float64(c) {
+			t.Fatalf("pq.Cap(): got %d for %d entries; want it shrunk", c, l)
+		}
+	}
+
+	if got := pq.Cap(); got >= peak {
+		t.Errorf("pq.Cap(): got %d; want less than %d", got, peak)
+	}
+
+	last := -1
+	for !pq.IsEmpty() {
+		_, got, _ := pq.Pop()
+		if got < last {
+			t.Fatalf("pq.Pop(): got value %d after %d; want non-decreasing values", got, last)
+		}
+		last = got
+	}
+	if got := pq.Cap(); got > minShrinkCap {
+		t.Errorf("pq.Cap(): got %d; want at most %d", got, minShrinkCap)
+	}
+}
+
+func TestWithAutoShrink_Headroom(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	}, WithAutoShrink[int, int](0.1, 4))
+
+	for k := 0; k < 1000; k++ {
+		pq.Push(k, k)
+	}
+	for pq.Cap() >= 1000 {
+		pq.Pop()
+	}
+
+	l, c := pq.Len(), pq.Cap()
+	if want := l * 4; c != want {
+		t.Fatalf("pq.Cap(): got %d for %d entries; want %d", c, l, want)
+	}
+	for k := 1000; pq.Len() < c; k++ {
+		pq.Push(k, k)
+	}
+	if got := pq.Cap(); got != c {
+		t.Errorf("pq.Cap(): got %d after filling the headroom; want %d", got, c)
+	}
+}
+
+func TestWithAutoShrink_InvalidArguments(t *testing.T) {
+	testCases := []struct {
+		name      string
+		threshold float64
+		headroom  float64
+	}{
+		{name: "NegativeThreshold", threshold: -1, headroom: 2},
+		{name: "ZeroThreshold", threshold: 0, headroom: 2},
+		{name: "ThresholdOne", threshold: 1, headroom: 2},
+		{name: "HeadroomOne", threshold: 0.25, headroom: 1},
+		{name: "HeadroomLessThanOne", threshold: 0.25, headroom: 0.5},
+		{name: "ThresholdTimesHeadroomOne", threshold: 0.5, headroom: 2},
+		{name: "ThresholdTimesHeadroomGreaterThanOne", threshold: 0.4, headroom: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err == nil {
+					t.Errorf("want WithAutoShrink(%v, %v) to panic", tc.threshold, tc.headroom)
+				}
+			}()
+
+			WithAutoShrink[int, int](tc.threshold, tc.headroom)
+		})
+	}
+}
+
+func TestWithGrowthFactor(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	}, WithCapacity[int, int](4), WithGrowthFactor[int, int](1.5))
+
+	wantCaps := []int{4, 6, 9, 13, 19}
+	for _, want := range wantCaps {
+		for pq.Len() < want {
+			pq.Push(pq.Len(), -pq.Len())
+		}
+		if got := pq.Cap(); got != want {
+			t.Errorf("pq.Cap(): got %d with %d entries; want %d", got, pq.Len(), want)
+		}
+	}
+
+	if err := pq.CheckInvariant(); err != nil {
+		t.Errorf("pq.CheckInvariant(): got %v; want nil", err)
+	}
+	for want := pq.Len() - 1; want >= 0; want-- {
+		if k, _ := pq.MustPop(); k != want {
+			t.Fatalf("pq.MustPop(): got key %d; want %d", k, want)
+		}
+	}
+}
+
+func TestWithGrowthFactor_Empty(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	}, WithGrowthFactor[int, int](2))
+
+	for k := 0; k < 3; k++ {
+		pq.Push(k, k)
+	}
+	if got, want := pq.Cap(), 4; got != want {
+		t.Errorf("pq.Cap(): got %d; want %d", got, want)
+	}
+}
+
+func TestWithGrowthFactor_InvalidFactor(t *testing.T) {
+	for _, f := range []float64{-1, 0, 0.5, 1, math.NaN()} {
+		t.Run(fmt.Sprint(f), func(t *testing.T) {
+			defer func() {
+				if err := recover(); err == nil {
+					t.Errorf("want WithGrowthFactor(%v) to panic", f)
+				}
+			}()
+
+			WithGrowthFactor[int, int](f)
+		})
+	}
+}
+
+func TestWithCapacity(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	}, WithCapacity[int, int](64))
+
+	if got, want := pq.Cap(), 64; got != want {
+		t.Errorf("pq.Cap(): got %d; want %d", got, want)
+	}
+
+	for k := 0; k < 64; k++ {
+		pq.Push(k, k)
+	}
+
+	if got, want := pq.Cap(), 64; got != want {
+		t.Errorf("pq.Cap() after 64 pushes: got %d; want %d", got, want)
+	}
+}
+
+func TestWithStableOrdering(t *testing.T) {
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "b1", val: 2},
+		{key: "a1", val: 1},
+		{key: "b2", val: 2},
+		{key: "a2", val: 1},
+		{key: "b3", val: 2},
+		{key: "a3", val: 1},
+		{key: "a4", val: 1},
+	}
+	wantKeys := []string{"a1", "a2", "a3", "a4", "b1", "b2", "b3"}
+
+	testCases := []struct {
+		name string
+		pq   *KeyedPriorityQueue[string, int]
+	}{
+		{
+			name: "CmpFunc",
+			pq: NewKeyedPriorityQueue[string](func(x, y int) bool {
+				return x < y
+			}, WithStableOrdering[string, int]()),
+		},
+		{
+			name: "Compare",
+			pq: NewWithCompare[string](func(x, y int) int {
+				return x - y
+			}, WithStableOrdering[string, int]()),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := tc.pq
+			for _, item := range items {
+				if err := pq.Push(item.key, item.val); err != nil {
+					t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+				}
+			}
+
+			// updating to the same priority keeps the insertion order.
+			if err := pq.Update("a1", 1); err != nil {
+				t.Fatalf("pq.Update(%q, 1): got unexpected error %v", "a1", err)
+			}
+
+			for _, want := range wantKeys {
+				got, _, ok := pq.Pop()
+				if !ok {
+					t.Fatal("pq.Pop(): got unexpected empty priority queue")
+				}
+				if got != want {
+					t.Errorf("pq.Pop(): got key %q; want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWithStableOrdering_Policy(t *testing.T) {
+	testCases := []struct {
+		name     string
+		policy   []StablePolicy
+		wantKeys []string
+	}{
+		{name: "Default", wantKeys: []string{"a1", "a2", "a3", "b1"}},
+		{name: "KeepOriginalSequence", policy: []StablePolicy{KeepOriginalSequence}, wantKeys: []string{"a1", "a2", "a3", "b1"}},
+		{name: "RefreshSequence", policy: []StablePolicy{RefreshSequence}, wantKeys: []string{"a2", "a3", "a1", "b1"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+				return x < y
+			}, WithStableOrdering[string, int](tc.policy...))
+
+			pq.MustPush("a1", 1)
+			pq.MustPush("b1", 2)
+			pq.MustPush("a2", 1)
+			pq.MustPush("a3", 1)
+
+			if err := pq.Update("a1", 1); err != nil {
+				t.Fatalf("pq.Update(%q, 1): got unexpected error %v", "a1", err)
+			}
+
+			for _, want := range tc.wantKeys {
+				if got, _ := pq.MustPop(); got != want {
+					t.Errorf("pq.MustPop(): got key %q; want %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWithStableOrdering_InvalidPolicy(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy []StablePolicy
+	}{
+		{name: "Unknown", policy: []StablePolicy{StablePolicy(2)}},
+		{name: "Many", policy: []StablePolicy{KeepOriginalSequence, RefreshSequence}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err == nil {
+					t.Errorf("want WithStableOrdering to panic when receiving the policies %v", tc.policy)
+				}
+			}()
+
+			WithStableOrdering[string, int](tc.policy...)
+		})
+	}
+}
+
+func TestWithMaxSize(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithMaxSize[string, int](3, true))
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+		if got := pq.Len(); got > 3 {
+			t.Fatalf("pq.Len(): got %d; want at most 3", got)
+		}
+	}
+
+	for _, k := range []string{"fourth", "last"} {
+		if pq.Contains(k) {
+			t.Errorf("pq.Contains(%q): got unexpected key in bounded priority queue", k)
+		}
+	}
+
+	for _, want := range []string{"first", "second", "third"} {
+		got, _, ok := pq.Pop()
+		if !ok {
+			t.Fatal("pq.Pop(): got unexpected empty priority queue")
+		}
+		if got != want {
+			t.Errorf("pq.Pop(): got key %q; want %q", got, want)
+		}
+	}
+}
+
+func TestWithMaxSize_WithoutEviction(t *testing.T) {
+	var evicted []string
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithMaxSize[string, int](2, false), WithOnEvict(func(k string, v int, reason EvictReason) {
+		if reason == EvictCapacity {
+			evicted = append(evicted, k)
+		}
+	}))
+
+	pq.MustPush("second", 8)
+	pq.MustPush("third", 9)
+
+	err := pq.Push("first", 6)
+	var wantErr CapacityExceededError[string]
+	if !errors.As(err, &wantErr) {
+		t.Fatalf("pq.Push(%q, 6): got error type %T; want it to be %T", "first", err, wantErr)
+	}
+	if got := wantErr.Key(); got != "first" {
+		t.Errorf("CapacityExceededError.Key(): got %q; want %q", got, "first")
+	}
+
+	if pq.Offer("first", 6) {
+		t.Errorf("pq.Offer(%q, 6): got true on full priority queue; want false", "first")
+	}
+
+	func() {
+		defer func() {
+			err, _ := recover().(error)
+			if !errors.As(err, &wantErr) {
+				t.Errorf("pq.Set(%q, 6): got panic %v; want a %T", "first", err, wantErr)
+			}
+		}()
+		pq.Set("first", 6)
+	}()
+	if pq.Contains("first") {
+		t.Errorf("pq.Set(%q, 6): got key inserted in full priority queue", "first")
+	}
+	if len(evicted) != 0 {
+		t.Errorf("pq.Set(%q, 6): got evicted keys %v; want none", "first", evicted)
+	}
+
+	// updating existing keys is still allowed.
+	if err := pq.Update("third", 1); err != nil {
+		t.Errorf("pq.Update(%q, 1): got unexpected error %v", "third", err)
+	}
+
+	pq.Pop()
+	if err := pq.Push("first", 6); err != nil {
+		t.Errorf("pq.Push(%q, 6): got unexpected error %v after Pop", "first", err)
+	}
+	if got, want := pq.Len(), 2; got != want {
+		t.Errorf("pq.Len(): got %d; want %d", got, want)
+	}
+}
+
+func TestWithMaxSize_InvalidSize(t *testing.T) {
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("want WithMaxSize(0) to panic")
+		}
+	}()
+
+	WithMaxSize[int, int](0, true)
+}
+
+func TestWithUpsertPush(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithUpsertPush[string, int]())
+
+	for _, v := range []int{10, 20, 5} {
+		if err := pq.Push("key", v); err != nil {
+			t.Fatalf("pq.Push(%q, %d): got unexpected error %v", "key", v, err)
+		}
+		if got, _ := pq.ValueOf("key"); got != v {
+			t.Errorf("pq.ValueOf(%q): got %d; want %d", "key", got, v)
+		}
+	}
+	pq.MustPush("key", 7)
+
+	if ok := pq.Offer("key", 1); ok {
+		t.Errorf("pq.Offer(%q, 1): got true; want false", "key")
+	}
+
+	if got, want := pq.Len(), 1; got != want {
+		t.Errorf("pq.Len(): got %d; want %d", got, want)
+	}
+	if got, _ := pq.ValueOf("key"); got != 7 {
+		t.Errorf("pq.ValueOf(%q): got %d; want %d", "key", got, 7)
+	}
+}
+
+func TestWithoutDuplicateCheck(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	}, WithoutDuplicateCheck[int, int](), WithLazyDeletion[int, int](1))
+
+	r := rand.New(rand.NewSource(1))
+	for k := 0; k < 100; k++ {
+		if err := pq.Push(k, r.Intn(1000)); err != nil {
+			t.Fatalf("pq.Push(%d, _): got unexpected error %v", k, err)
+		}
+	}
+
+	// pushing lazily removed keys again must still drop their tombstones.
+	for k := 0; k < 10; k++ {
+		pq.Remove(k)
+		pq.MustPush(k, r.Intn(1000))
+	}
+
+	if got, want := pq.Len(), 100; got != want {
+		t.Errorf("pq.Len(): got %d; want %d", got, want)
+	}
+	if err := pq.CheckInvariant(); err != nil {
+		t.Errorf("pq.CheckInvariant(): got unexpected error %v", err)
+	}
+	if ok := pq.Offer(0, 0); ok {
+		t.Errorf("pq.Offer(0, 0): got true for an existing key; want false")
+	}
+}
+
+func TestWithComparatorValidation(t *testing.T) {
+	items := []Item[string, int]{{Key: "first", Value: 1}, {Key: "second", Value: 2}, {Key: "third", Value: 2}}
+
+	testCases := []struct {
+		name      string
+		cmp       CmpFunc[int]
+		wantPanic bool
+	}{
+		{name: "Strict", cmp: func(x, y int) bool { return x < y }},
+		{name: "NonStrict", cmp: func(x, y int) bool { return x <= y }, wantPanic: true},
+		{name: "Inconsistent", cmp: func(x, y int) bool { return x != y }, wantPanic: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				err := recover()
+				if tc.wantPanic && err == nil {
+					t.Error("want NewFromItems to panic with an invalid comparison function")
+				}
+				if !tc.wantPanic && err != nil {
+					t.Errorf("NewFromItems(): got unexpected panic %v", err)
+				}
+			}()
+
+			NewFromItems(tc.cmp, items, WithComparatorValidation[string, int]())
+		})
+	}
+
+	// without the option, the comparison function isn't checked.
+	if _, err := NewFromItems(func(x, y int) bool { return x <= y }, items); err != nil {
+		t.Errorf("NewFromItems(): got unexpected error %v", err)
+	}
+}
+
+func TestWithMonotoneIncreasing(t *testing.T) {
+	var calls int
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		calls++
+		return x < y
+	}, WithMonotoneIncreasing[int, int]())
+
+	r := rand.New(rand.NewSource(1))
+	n := 100
+	for k := 0; k < n; k++ {
+		pq.Push(k, r.Intn(n))
+	}
+
+	// sinking a leaf takes no comparisons, and there's no comparison deciding whether to swim it instead.
+	leaf := pq.pm[len(pq.pm)-1]
+	v, _ := pq.ValueOf(leaf)
+	calls = 0
+	pq.Update(leaf, v+1)
+	if calls != 0 {
+		t.Errorf("pq.Update(%d, %d): got %d comparisons updating a leaf; want 0", leaf, v+1, calls)
+	}
+
+	for i := 0; i < 1000; i++ {
+		k := r.Intn(n)
+		v, _ := pq.ValueOf(k)
+		if i%2 == 0 {
+			pq.Update(k, v+r.Intn(n))
+		} else {
+			pq.Set(k, v+r.Intn(n))
+		}
+		if err := pq.CheckInvariant(); err != nil {
+			t.Fatalf("pq.CheckInvariant(): got %v after %d updates; want nil", err, i+1)
+		}
+	}
+}
+
+func TestWithComparatorCaching(t *testing.T) {
+	var cmpCalls, keyCalls int
+	parse := func(s string) int {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			t.Fatalf("strconv.Atoi(%q): got unexpected error %v", s, err)
+		}
+		return n
+	}
+	pq := NewKeyedPriorityQueue[int](func(x, y string) bool {
+		cmpCalls++
+		return parse(x) < parse(y)
+	}, WithStableOrdering[int, string](), WithLazyDeletion[int, string](0.5), WithComparatorCaching[int](func(v string) int {
+		keyCalls++
+		return parse(v)
+	}))
+
+	r := rand.New(rand.NewSource(1))
+	n := 200
+	for k := 0; k < n; k++ {
+		pq.Push(k, strconv.Itoa(r.Intn(n)))
+	}
+	if cmpCalls != 0 || keyCalls != n {
+		t.Errorf("Push: got %d cmp calls and %d key calls; want 0 and %d", cmpCalls, keyCalls, n)
+	}
+
+	for i := 0; i < 1000; i++ {
+		k := r.Intn(n)
+		switch r.Intn(5) {
+		case 0:
+			pq.Set(k, strconv.Itoa(r.Intn(n)))
+		case 1:
+			pq.Remove(k)
+		case 2:
+			pq.Pop()
+		case 3:
+			pq.RenameKey(k, k+n)
+		default:
+			pq.Push(k, strconv.Itoa(r.Intn(n)))
+		}
+		if err := pq.CheckInvariant(); err != nil {
+			t.Fatalf("pq.CheckInvariant(): got %v after %d operations; want nil", err, i+1)
+		}
+	}
+
+	part := pq.Partition(func(k int, v string) bool { return k%2 == 0 })
+	pq.TrimToSize()
+
+	other := NewKeyedPriorityQueue[int](func(x, y string) bool {
+		return parse(x) < parse(y)
+	}, WithStableOrdering[int, string]())
+	other.Push(-1, "0")
+	part.Swap(other)
+
+	for name, q := range map[string]*KeyedPriorityQueue[int, string]{"pq": pq, "part": part, "other": other} {
+		if err := q.CheckInvariant(); err != nil {
+			t.Errorf("%s.CheckInvariant(): got %v; want nil", name, err)
+		}
+		last := -1
+		for !q.IsEmpty() {
+			_, v := q.MustPop()
+			if got := parse(v); got < last {
+				t.Fatalf("%s.MustPop(): got value %d after %d; want non-decreasing values", name, got, last)
+			}
+			last = parse(v)
+		}
+	}
+}
+
+func TestWithValueCopier(t *testing.T) {
+	less := func(x, y []int) bool {
+		return x[0] < y[0]
+	}
+	clone := func(v []int) []int {
+		return append([]int(nil), v...)
+	}
+
+	testCases := []struct {
+		name       string
+		opts       []Option[string, []int]
+		wantShared bool
+	}{
+		{name: "Default", wantShared: true},
+		{name: "Copier", opts: []Option[string, []int]{WithValueCopier[string](clone)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := NewKeyedPriorityQueue[string](less, tc.opts...)
+			pq.MustPush("first", []int{1})
+			pq.MustPush("second", []int{2})
+
+			_, top, _ := pq.Peek()
+			top[0] = 30
+			v, _ := pq.ValueOf("second")
+			v[0] = 20
+			if got := pq.GetOr("second", nil); (got[0] == 20) != tc.wantShared {
+				t.Errorf("pq.GetOr(%q, nil): got %v after mutating the value of pq.ValueOf; want shared %t", "second", got, tc.wantShared)
+			}
+
+			if v, _ := pq.PeekValue(); (v[0] == 30) != tc.wantShared {
+				t.Errorf("pq.PeekValue(): got %v after mutating the value of pq.Peek; want shared %t", v, tc.wantShared)
+			}
+			// mutating shared values breaks the heap ordering, as the top value is now the highest one.
+			if err := pq.CheckInvariant(); (err != nil) != tc.wantShared {
+				t.Errorf("pq.CheckInvariant(): got error %v; want one only if values are shared", err)
+			}
+		})
+	}
+}
+
+func TestWithValueCopier_View(t *testing.T) {
+	less := func(x, y []int) bool {
+		return x[0] < y[0]
+	}
+	clone := func(v []int) []int {
+		return append([]int(nil), v...)
+	}
+
+	testCases := []struct {
+		name       string
+		opts       []Option[string, []int]
+		wantShared bool
+	}{
+		{name: "Default", wantShared: true},
+		{name: "Copier", opts: []Option[string, []int]{WithValueCopier[string](clone)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pq := NewKeyedPriorityQueue[string](less, tc.opts...)
+			pq.MustPush("first", []int{1})
+			pq.MustPush("second", []int{2})
+			pq.MustPush("third", []int{3})
+
+			pq.View(func(r ReadOnly[string, []int]) {
+				_, top, _ := r.Peek()
+				top[0] = 30
+				v, _ := r.ValueOf("second")
+				v[0] = 20
+			})
+			s := pq.Snapshot()
+			v, _ := s.ValueOf("third")
+			v[0] = 0
+
+			if got, _ := pq.ValueOf("second"); (got[0] == 20) != tc.wantShared {
+				t.Errorf("pq.ValueOf(%q): got %v after mutating the value of r.ValueOf; want shared %t", "second", got, tc.wantShared)
+			}
+			if got, _ := pq.ValueOf("third"); (got[0] == 0) != tc.wantShared {
+				t.Errorf("pq.ValueOf(%q): got %v after mutating the value of s.ValueOf; want shared %t", "third", got, tc.wantShared)
+			}
+			if err := pq.CheckInvariant(); (err != nil) != tc.wantShared {
+				t.Errorf("pq.CheckInvariant(): got error %v; want one only if values are shared", err)
+			}
+		})
+	}
+}
+
+func TestWithValueCopier_Nil(t *testing.T) {
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("want WithValueCopier(nil) to panic")
+		}
+	}()
+
+	WithValueCopier[int, int](nil)
+}
+
+func TestWithComparatorCaching_Compaction(t *testing.T) {
+	less := func(x, y int) bool {
+		return x < y
+	}
+	pq := NewKeyedPriorityQueue[int](less, WithLazyDeletion[int, int](0.1), WithComparatorCaching[int](func(v int) int {
+		return v
+	}))
+
+	r := rand.New(rand.NewSource(1))
+	n := 200
+	for k := 0; k < n; k++ {
+		pq.Push(k, r.Intn(n))
+	}
+
+	// removing keys lazily purges the heap often, moving the remaining entries along with their sort keys.
+	for i := 0; i < 500; i++ {
+		k := r.Intn(n)
+		if r.Intn(2) == 0 {
+			pq.Remove(k)
+		} else {
+			pq.Set(k, r.Intn(n))
+		}
+		if err := pq.CheckInvariant(); err != nil {
+			t.Fatalf("pq.CheckInvariant(): got %v after %d operations; want nil", err, i+1)
+		}
+	}
+
+	part := pq.Partition(func(k int, v int) bool { return v%2 == 0 })
+	for name, q := range map[string]*KeyedPriorityQueue[int, int]{"pq": pq, "part": part} {
+		if err := q.CheckInvariant(); err != nil {
+			t.Errorf("%s.CheckInvariant(): got %v; want nil", name, err)
+		}
+		last := -1
+		for !q.IsEmpty() {
+			_, v := q.MustPop()
+			if v < last {
+				t.Fatalf("%s.MustPop(): got value %d after %d; want non-decreasing values", name, v, last)
+			}
+			last = v
+		}
+	}
+}
+
+func TestWithArity(t *testing.T) {
+	for _, d := range []int{2, 3, 4, 8} {
+		t.Run(fmt.Sprint(d), func(t *testing.T) {
+			pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+				return x < y
+			}, WithArity[int, int](d))
+
+			r := rand.New(rand.NewSource(int64(d)))
+			n := 300
+			vals := make(map[int]int, n)
+			for k := 0; k < n; k++ {
+				vals[k] = r.Intn(1000)
+				pq.Push(k, vals[k])
+			}
+			for k := 0; k < n; k += 3 {
+				vals[k] = r.Intn(1000)
+				pq.Update(k, vals[k])
+			}
+			for k := 1; k < n; k += 5 {
+				pq.Remove(k)
+				delete(vals, k)
+			}
+
+			if err := pq.CheckInvariant(); err != nil {
+				t.Fatalf("pq.CheckInvariant(): got unexpected error: %v", err)
+			}
+
+			want := make([]int, 0, len(vals))
+			for _, v := range vals {
+				want = append(want, v)
+			}
+			sort.Ints(want)
+
+			for _, wantVal := range want {
+				_, got, ok := pq.Pop()
+				if !ok {
+					t.Fatal("pq.Pop(): got unexpected empty priority queue")
+				}
+				if got != wantVal {
+					t.Fatalf("pq.Pop(): got value %d; want %d", got, wantVal)
+				}
+			}
+		})
+	}
+}
+
+func TestWithArity_InvalidArity(t *testing.T) {
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("want WithArity(1) to panic")
+		}
+	}()
+
+	WithArity[int, int](1)
+}
+
+func TestWithLazyDeletion(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	}, WithLazyDeletion[int, int](0.5))
+
+	r := rand.New(rand.NewSource(1))
+	n := 100
+	vals := make(map[int]int, n)
+	for k := 0; k < n; k++ {
+		vals[k] = r.Intn(1000)
+		pq.Push(k, vals[k])
+	}
+
+	for i := 0; i < 2000; i++ {
+		k := r.Intn(n)
+		switch r.Intn(5) {
+		case 0:
+			pq.Remove(k)
+			delete(vals, k)
+		case 1:
+			v := r.Intn(1000)
+			err := pq.Update(k, v)
+			if _, ok := vals[k]; !ok {
+				if err == nil {
+					t.Fatalf("pq.Update(%d, %d): got nil error for removed key", k, v)
+				}
+				continue
+			}
+			vals[k] = v
+		case 2:
+			v := r.Intn(1000)
+			pq.Set(k, v)
+			vals[k] = v
+		case 3:
+			v := r.Intn(1000)
+			err := pq.Push(k, v)
+			if _, ok := vals[k]; ok != (err != nil) {
+				t.Fatalf("pq.Push(%d, %d): got error %v; want error %t", k, v, err, ok)
+			}
+			if err == nil {
+				vals[k] = v
+			}
+		default:
+			k, v, ok := pq.Pop()
+			if !ok {
+				continue
+			}
+			if want, ok := vals[k]; !ok || want != v {
+				t.Fatalf("pq.Pop(): got unexpected (%d, %d)", k, v)
+			}
+			for _, other := range vals {
+				if other < v {
+					t.Fatalf("pq.Pop(): got value %d; want at most %d", v, other)
+				}
+			}
+			delete(vals, k)
+		}
+
+		if got, want := pq.Len(), len(vals); got != want {
+			t.Fatalf("pq.Len(): got %d; want %d", got, want)
+		}
+		if err := pq.CheckInvariant(); err != nil {
+			t.Fatalf("pq.CheckInvariant(): got unexpected error: %v", err)
+		}
+		if got, max := len(pq.dead), 0.5*float64(pq.Len()); float64(got) > max {
+			t.Fatalf("len(pq.dead): got %d tombstones; want at most %v", got, max)
+		}
+		want, wantOK := vals[k]
+		if got, ok := pq.ValueOf(k); ok != wantOK || got != want {
+			t.Fatalf("pq.ValueOf(%d): got (%d, %t); want (%d, %t)", k, got, ok, want, wantOK)
+		}
+		if got := pq.Contains(k); got != wantOK {
+			t.Fatalf("pq.Contains(%d): got %t; want %t", k, got, wantOK)
+		}
+	}
+}
+
+func TestWithLazyDeletion_InvalidFraction(t *testing.T) {
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("want WithLazyDeletion(0) to panic")
+		}
+	}()
+
+	WithLazyDeletion[int, int](0)
+}
+
+func TestWithOnEvict(t *testing.T) {
+	type eviction struct {
+		key    string
+		val    int
+		reason EvictReason
+	}
+
+	var pq *KeyedPriorityQueue[string, int]
+	var got []eviction
+	pq = NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithMaxSize[string, int](3, true), WithOnEvict(func(k string, v int, reason EvictReason) {
+		// the lock must be released when the callback runs.
+		pq.Contains(k)
+		got = append(got, eviction{key: k, val: v, reason: reason})
+	}))
+
+	pq.Push("a", 1)
+	pq.Push("b", 2)
+	pq.Push("c", 3)
+	pq.Push("d", 0)  // evicts c
+	pq.Push("e", 10) // discarded
+	pq.Pop()         // pops d
+	pq.Remove("b")
+	pq.Remove("b") // no-op
+
+	want := []eviction{
+		{key: "c", val: 3, reason: EvictCapacity},
+		{key: "e", val: 10, reason: EvictCapacity},
+		{key: "d", val: 0, reason: EvictPop},
+		{key: "b", val: 2, reason: EvictRemove},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("evictions: got %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("eviction #%d: got %v; want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestEvictReason_String(t *testing.T) {
+	testCases := []struct {
+		reason EvictReason
+		want   string
+	}{
+		{reason: EvictPop, want: "pop"},
+		{reason: EvictRemove, want: "remove"},
+		{reason: EvictCapacity, want: "capacity"},
+		{reason: EvictReason(42), want: "EvictReason(42)"},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.reason.String(); got != tc.want {
+			t.Errorf("EvictReason(%d).String(): got %q; want %q", int(tc.reason), got, tc.want)
+		}
+	}
+}
+
+type countingMetrics struct {
+	pushes, pops, updates, removes, evictions int
+	sifts, maxDepth                           int
+}
+
+func (m *countingMetrics) IncPush()   { m.pushes++ }
+func (m *countingMetrics) IncPop()    { m.pops++ }
+func (m *countingMetrics) IncUpdate() { m.updates++ }
+func (m *countingMetrics) IncRemove() { m.removes++ }
+func (m *countingMetrics) IncEvict()  { m.evictions++ }
+
+func (m *countingMetrics) ObserveSiftDepth(depth int) {
+	m.sifts++
+	if depth > m.maxDepth {
+		m.maxDepth = depth
+	}
+}
+
+func TestWithMetrics(t *testing.T) {
+	m := &countingMetrics{}
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithMaxSize[string, int](4, true), WithMetrics[string, int](m))
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		pq.Push(item.key, item.val)
+	}
+	pq.Update("fourth", 1)
+	pq.Set("second", 2)
+	pq.Remove("third")
+	pq.Pop()
+
+	want := countingMetrics{pushes: 4, pops: 1, updates: 2, removes: 1, evictions: 1}
+	got := *m
+	got.sifts, got.maxDepth = 0, 0
+	if got != want {
+		t.Errorf("metrics: got %+v; want %+v", got, want)
+	}
+	if m.sifts == 0 || m.maxDepth == 0 {
+		t.Errorf("metrics: got %d sifts with max depth %d; want some", m.sifts, m.maxDepth)
+	}
+}
+
+func TestWithMetrics_Load(t *testing.T) {
+	items := []Item[string, int]{
+		{Key: "second", Value: 8},
+		{Key: "first", Value: 6},
+		{Key: "third", Value: 9},
+	}
+
+	testCases := []struct {
+		name string
+		opts []Option[string, int]
+	}{
+		{name: "Unbounded"},
+		{name: "Bounded", opts: []Option[string, int]{WithMaxSize[string, int](4, true)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &countingMetrics{}
+			opts := append(tc.opts, WithMetrics[string, int](m))
+			if _, err := NewFromItems(func(x, y int) bool { return x < y }, items, opts...); err != nil {
+				t.Fatalf("NewFromItems(): got unexpected error %v", err)
+			}
+
+			if got, want := m.pushes, len(items); got != want {
+				t.Errorf("metrics: got %d pushes; want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestWithMetrics_Nil(t *testing.T) {
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("want WithMetrics(nil) to panic")
+		}
+	}()
+
+	WithMetrics[int, int](nil)
+}
@@ -0,0 +1,64 @@
+package kpq
+
+// HeapSort sorts s in place by priority using the given cmp function, following the same convention
+// as the priority queue: cmp(x, y) returns true if x has a higher priority than y, and higher priority values come first.
+// It's the order in which the values would be popped from a priority queue ordered by cmp,
+// without the need of creating one.
+// It runs in O(n log n) time without allocating, but it's not stable: equal values may be reordered.
+//
+// HeapSort will panic if cmp is nil.
+func HeapSort[V any](s []V, cmp CmpFunc[V]) {
+	if cmp == nil {
+		panic("keyed priority queue: comparison function cannot be nil")
+	}
+	heapSort(s, cmp)
+}
+
+// heapSort sorts s in place, in such a way that less(s[j], s[i]) is false for any i < j.
+// It runs in O(n log n) time without allocating, but it's not stable.
+func heapSort[T any](s []T, less func(a, b T) bool) {
+	// the root of a heap ordered by greater is the last element per less,
+	// which is moved to the end of s on each extraction.
+	greater := func(a, b T) bool {
+		return less(b, a)
+	}
+	heapify(s, greater)
+	for n := len(s) - 1; n > 0; n-- {
+		s[0], s[n] = s[n], s[0]
+		down(s, 0, n, greater)
+	}
+}
+
+// heapify rearranges s into a binary heap ordered by less.
+func heapify[T any](s []T, less func(a, b T) bool) {
+	for i := len(s)/2 - 1; i >= 0; i-- {
+		down(s, i, len(s), less)
+	}
+}
+
+// down moves s[i] down the binary heap s[:n] ordered by less.
+func down[T any](s []T, i, n int, less func(a, b T) bool) {
+	for hasChild(i, n, 2) {
+		j := leftChild(i, 2)
+		if r := j + 1; r < n && less(s[r], s[j]) {
+			j = r
+		}
+		if !less(s[j], s[i]) {
+			return
+		}
+		s[i], s[j] = s[j], s[i]
+		i = j
+	}
+}
+
+// up moves s[i] up the binary heap s ordered by less.
+func up[T any](s []T, i int, less func(a, b T) bool) {
+	for i > 0 {
+		p := parent(i, 2)
+		if !less(s[i], s[p]) {
+			return
+		}
+		s[i], s[p] = s[p], s[i]
+		i = p
+	}
+}
@@ -0,0 +1,109 @@
+package kpq
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestHeapSort_Internal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 1, 2, 3, 10, 100} {
+		s := make([]int, n)
+		for i := range s {
+			s[i] = r.Intn(50)
+		}
+		want := append([]int(nil), s...)
+		sort.Ints(want)
+
+		heapSort(s, func(a, b int) bool { return a < b })
+
+		for i := range want {
+			if s[i] != want[i] {
+				t.Fatalf("heapSort(n=%d): got %v; want %v", n, s, want)
+			}
+		}
+	}
+}
+
+func TestHeapSort(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 1, 2, 10, 100} {
+		s := make([]int, n)
+		for i := range s {
+			s[i] = r.Intn(50)
+		}
+		want := append([]int(nil), s...)
+		sort.Sort(sort.Reverse(sort.IntSlice(want)))
+
+		HeapSort(s, func(x, y int) bool { return x > y })
+
+		for i := range want {
+			if s[i] != want[i] {
+				t.Fatalf("HeapSort(n=%d): got %v; want %v", n, s, want)
+			}
+		}
+	}
+}
+
+func TestHeapSort_NotStable(t *testing.T) {
+	s := []Item[string, int]{{Key: "first", Value: 1}, {Key: "second", Value: 1}}
+	in := append([]Item[string, int](nil), s...)
+
+	HeapSort(s, func(x, y Item[string, int]) bool { return x.Value < y.Value })
+
+	// equal values aren't kept in their original order.
+	want := []Item[string, int]{{Key: "second", Value: 1}, {Key: "first", Value: 1}}
+	for i := range want {
+		if s[i] != want[i] {
+			t.Fatalf("HeapSort(%v): got %v; want %v", in, s, want)
+		}
+	}
+}
+
+func TestHeapSort_NilCmp(t *testing.T) {
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("want HeapSort to panic when receiving a nil comparison function")
+		}
+	}()
+
+	HeapSort([]int{1}, nil)
+}
+
+func TestHeapIndexArithmetic_Overflow(t *testing.T) {
+	// heaps near math.MaxInt can't be allocated, so the index arithmetic
+	// is checked against big integers instead.
+	sizes := []int{0, 1, 2, 3, 10, math.MaxInt32 - 1, math.MaxInt32, math.MaxInt - 1, math.MaxInt}
+	arities := []int{2, 3, 4, 16, math.MaxInt32, math.MaxInt}
+
+	for _, n := range sizes {
+		for _, d := range arities {
+			positions := []int{0, 1, n / d, n/d - 1, n/d + 1, (n - 2) / d, (n-2)/d + 1, n / 2, n - 2, n - 1}
+			for _, i := range positions {
+				if i < 0 || i >= n {
+					continue
+				}
+
+				// the first child of i is i*d+1.
+				child := new(big.Int).Mul(big.NewInt(int64(i)), big.NewInt(int64(d)))
+				child.Add(child, big.NewInt(1))
+				want := child.Cmp(big.NewInt(int64(n))) < 0
+
+				got := hasChild(i, n, d)
+				if got != want {
+					t.Errorf("hasChild(%d, %d, %d): got %t; want %t", i, n, d, got, want)
+				}
+				if got && int64(leftChild(i, d)) != child.Int64() {
+					t.Errorf("leftChild(%d, %d): got %d; want %s", i, d, leftChild(i, d), child)
+				}
+
+				if p := parent(i, d); i > 0 && (p < 0 || p >= i) {
+					t.Errorf("parent(%d, %d): got %d; want a position in [0, %d)", i, d, p, i)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package kpq
+
+import "cmp"
+
+// sortKeyCache caches the sort keys of the entries of a priority queue created with the WithComparatorCaching option,
+// by heap position, aligned with the priority values of the entries.
+// It erases the type of the sort keys, which isn't a type parameter of KeyedPriorityQueue.
+type sortKeyCache[V any] interface {
+	// set computes and caches the sort key of the entry at the position i from its priority value v.
+	// i may be the number of cached sort keys, in which case the sort key is appended.
+	set(i int, v V)
+	// swap swaps the cached sort keys at the positions i and j.
+	swap(i, j int)
+	// truncate discards the cached sort keys from the position n on.
+	truncate(n int)
+	// compare compares the cached sort keys at the positions i and j, like cmp.Compare.
+	compare(i, j int) int
+	// reset discards all the cached sort keys, making room for n sort keys.
+	reset(n int)
+	// realloc moves the cached sort keys to a new backing array with room for c sort keys.
+	realloc(c int)
+	// clone returns a new empty cache with the same sort key function, with room for n sort keys.
+	clone(n int) sortKeyCache[V]
+}
+
+// sortKeys is a sortKeyCache whose sort keys are of type S.
+type sortKeys[V any, S cmp.Ordered] struct {
+	key func(v V) S
+	s   []S // s[i] is the sort key of the entry at the position i of the heap
+}
+
+func newSortKeys[V any, S cmp.Ordered](key func(v V) S, n int) *sortKeys[V, S] {
+	return &sortKeys[V, S]{key: key, s: make([]S, 0, n)}
+}
+
+func (c *sortKeys[V, S]) set(i int, v V) {
+	if i == len(c.s) {
+		c.s = append(c.s, c.key(v))
+		return
+	}
+	c.s[i] = c.key(v)
+}
+
+func (c *sortKeys[V, S]) swap(i, j int) {
+	c.s[i], c.s[j] = c.s[j], c.s[i]
+}
+
+func (c *sortKeys[V, S]) truncate(n int) {
+	c.s = c.s[:n]
+}
+
+func (c *sortKeys[V, S]) compare(i, j int) int {
+	return cmp.Compare(c.s[i], c.s[j])
+}
+
+func (c *sortKeys[V, S]) reset(n int) {
+	c.s = make([]S, 0, n)
+}
+
+func (c *sortKeys[V, S]) realloc(n int) {
+	s := make([]S, len(c.s), n)
+	copy(s, c.s)
+	c.s = s
+}
+
+func (c *sortKeys[V, S]) clone(n int) sortKeyCache[V] {
+	return newSortKeys(c.key, n)
+}
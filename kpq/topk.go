@@ -0,0 +1,88 @@
+package kpq
+
+import "sync"
+
+// TopK keeps track of the K highest priority entries offered to it,
+// out of a stream of keys and priority values of arbitrary length.
+//
+// TopK is built on a KeyedPriorityQueue holding at most K entries, ordered from the lowest to the highest priority,
+// so the entry to evict when a better one is offered is always at its top.
+//
+// TopK is safe for concurrent use.
+type TopK[K comparable, V any] struct {
+	mu sync.Mutex
+
+	k   int
+	cmp CmpFunc[V]
+	pq  *KeyedPriorityQueue[K, V] // lowest priority entry on top
+}
+
+// NewTopK returns a new TopK that keeps the k highest priority entries,
+// according to the given cmp function.
+//
+// NewTopK will panic if k is not positive or if cmp is nil.
+func NewTopK[K comparable, V any](k int, cmp CmpFunc[V]) *TopK[K, V] {
+	if k <= 0 {
+		panic("keyed priority queue: top k size must be positive")
+	}
+	if cmp == nil {
+		panic("keyed priority queue: comparison function cannot be nil")
+	}
+	reverse := func(x, y V) bool {
+		return cmp(y, x)
+	}
+	return &TopK[K, V]{
+		k:   k,
+		cmp: cmp,
+		pq:  NewKeyedPriorityQueue[K](reverse, WithoutLocking[K, V](), WithCapacity[K, V](k)),
+	}
+}
+
+// Offer submits the given key k with its priority value v.
+// If TopK already holds K entries, the lowest priority one is evicted to make room for k,
+// provided v has a higher priority than it; otherwise, k is discarded.
+//
+// If k is already held, its priority value is replaced only if v has a higher priority,
+// so each key is ranked by the highest priority value it has been offered with.
+//
+// Offer has O(log K) time complexity.
+func (t *TopK[K, V]) Offer(k K, v V) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if old, ok := t.pq.ValueOf(k); ok {
+		if t.cmp(v, old) {
+			t.pq.Update(k, v)
+		}
+		return
+	}
+
+	if t.pq.Len() < t.k {
+		t.pq.Push(k, v)
+		return
+	}
+
+	if _, worst, _ := t.pq.Peek(); !t.cmp(v, worst) {
+		return
+	}
+	t.pq.Pop()
+	t.pq.Push(k, v)
+}
+
+// Result returns the entries held by TopK, from the highest to the lowest priority.
+// TopK is left unchanged, so more entries can be offered afterwards.
+func (t *TopK[K, V]) Result() []Item[K, V] {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	items := t.pq.AppendSorted(make([]Item[K, V], 0, t.pq.Len()))
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	return items
+}
+
+// Len returns the number of entries held by TopK, which is at most K.
+func (t *TopK[K, V]) Len() int {
+	return t.pq.Len()
+}
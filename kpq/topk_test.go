@@ -0,0 +1,72 @@
+package kpq
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestTopK(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	for _, k := range []int{1, 3, 10, 100} {
+		topK := NewTopK[int](k, func(x, y int) bool {
+			return x < y
+		})
+
+		// best holds the highest priority value offered for each key.
+		best := make(map[int]int)
+		for i := 0; i < 1000; i++ {
+			key, val := r.Intn(200), r.Intn(10000)
+			topK.Offer(key, val)
+
+			if old, ok := best[key]; !ok || val < old {
+				best[key] = val
+			}
+		}
+
+		want := make([]Item[int, int], 0, len(best))
+		for key, val := range best {
+			want = append(want, Item[int, int]{Key: key, Value: val})
+		}
+		sort.Slice(want, func(i, j int) bool {
+			return want[i].Value < want[j].Value
+		})
+		if len(want) > k {
+			want = want[:k]
+		}
+
+		got := topK.Result()
+		if len(got) != len(want) || topK.Len() != len(want) {
+			t.Fatalf("topK.Result() with k=%d: got %d entries; want %d", k, len(got), len(want))
+		}
+		for i := range want {
+			if got[i].Value != want[i].Value || best[got[i].Key] != got[i].Value {
+				t.Errorf("topK.Result()[%d] with k=%d: got %v; want value %d", i, k, got[i], want[i].Value)
+			}
+		}
+	}
+}
+
+func TestNewTopK_InvalidArgs(t *testing.T) {
+	testCases := []struct {
+		name string
+		k    int
+		cmp  CmpFunc[int]
+	}{
+		{name: "ZeroK", k: 0, cmp: func(x, y int) bool { return x < y }},
+		{name: "NilCmp", k: 1, cmp: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if err := recover(); err == nil {
+					t.Errorf("want NewTopK(%d, cmp) to panic", tc.k)
+				}
+			}()
+
+			NewTopK[string](tc.k, tc.cmp)
+		})
+	}
+}
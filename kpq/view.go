@@ -0,0 +1,320 @@
+package kpq
+
+import (
+	"iter"
+	"sort"
+)
+
+// KeyedPriorityQueueView represents a point-in-time, read-only copy of a keyed priority queue.
+//
+// A KeyedPriorityQueueView is decoupled from the priority queue it was taken from:
+// further mutations of the priority queue aren't reflected in it.
+// Since it's immutable, it's safe for concurrent use without locking.
+type KeyedPriorityQueueView[K comparable, V any] struct {
+	pm   []K
+	vals map[K]V
+}
+
+// Snapshot returns a read-only view of the current state of the priority queue.
+// It copies the entries of the priority queue, so it has O(n) time complexity.
+func (pq *KeyedPriorityQueue[K, V]) Snapshot() *KeyedPriorityQueueView[K, V] {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	pm := make([]K, 0, pq.Len())
+	vals := make(map[K]V, pq.Len())
+	for i, k := range pq.pm {
+		if !pq.isDead(k) {
+			pm = append(pm, k)
+			vals[k] = pq.value(i)
+		}
+	}
+
+	return &KeyedPriorityQueueView[K, V]{
+		pm:   pm,
+		vals: vals,
+	}
+}
+
+// SnapshotSeq returns an iterator over the keys and values of the priority queue, in heap order.
+// The highest priority entry is yielded first, but the remaining ones aren't sorted by priority.
+//
+// The entries are copied when SnapshotSeq is called, under a brief read lock,
+// and the iterator yields from that copy without holding the lock.
+// Hence mutations of the priority queue after the call to SnapshotSeq aren't reflected in the iteration,
+// and the iterator may be ranged over several times, always yielding the same entries.
+func (pq *KeyedPriorityQueue[K, V]) SnapshotSeq() iter.Seq2[K, V] {
+	pq.mu.RLock()
+	items := make([]Item[K, V], 0, pq.Len())
+	for i, k := range pq.pm {
+		if !pq.isDead(k) {
+			items = append(items, Item[K, V]{Key: k, Value: pq.pv[i]})
+		}
+	}
+	pq.mu.RUnlock()
+
+	return func(yield func(K, V) bool) {
+		for _, item := range items {
+			if !yield(item.Key, item.Value) {
+				return
+			}
+		}
+	}
+}
+
+// SmallestSeq returns an iterator over up to n of the highest priority keys and values of the priority queue,
+// sorted by priority, e.g., for streaming the top n entries without sorting all of them.
+// Like SnapshotSeq, it copies the entries of the priority queue when it's called, so the priority queue is left untouched
+// and its mutations aren't reflected in the iteration. The entries are then extracted lazily from the copy,
+// so stopping the iteration early skips sorting the remaining ones.
+// It yields nothing if n isn't positive.
+//
+// Each iteration has O(m + n log m) time complexity, where m is the size of the priority queue.
+func (pq *KeyedPriorityQueue[K, V]) SmallestSeq(n int) iter.Seq2[K, V] {
+	s := pq.SortableSnapshot().(*SortableItems[K, V])
+
+	return func(yield func(K, V) bool) {
+		// the copy is left as is, so that the iterator may be ranged over several times;
+		// a heap of positions of its entries is built for each iteration instead.
+		h := make([]int, s.Len())
+		for i := range h {
+			h[i] = i
+		}
+		heapify(h, s.Less)
+		for i := 0; i < n && len(h) > 0; i++ {
+			item := s.items[h[0]]
+			if !yield(item.Key, item.Value) {
+				return
+			}
+			h[0] = h[len(h)-1]
+			h = h[:len(h)-1]
+			down(h, 0, len(h), s.Less)
+		}
+	}
+}
+
+// SortableSnapshot returns a copy of the entries of the priority queue, in heap order,
+// as a sort.Interface whose Less method orders them by priority, the highest priority one first.
+// Passing it to sort.Sort sorts the copy in place, and doesn't affect the priority queue.
+// Its dynamic type is *SortableItems, whose Items method gives access to the, possibly sorted, entries.
+// It copies the entries of the priority queue, so it has O(n) time complexity.
+func (pq *KeyedPriorityQueue[K, V]) SortableSnapshot() sort.Interface {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	s := &SortableItems[K, V]{
+		items:    make([]Item[K, V], 0, pq.Len()),
+		cmp:      pq.cmp,
+		compare3: pq.compare3,
+	}
+	if pq.seq != nil {
+		s.seq = make([]uint64, 0, pq.Len())
+	}
+	for i, k := range pq.pm {
+		if pq.isDead(k) {
+			continue
+		}
+		s.items = append(s.items, Item[K, V]{Key: k, Value: pq.pv[i]})
+		if s.seq != nil {
+			s.seq = append(s.seq, pq.seq[k])
+		}
+	}
+	return s
+}
+
+// SortableItems is a copy of the entries of a keyed priority queue that implements sort.Interface,
+// ordering the entries by priority as the priority queue it was taken from does.
+// It's returned by SortableSnapshot.
+type SortableItems[K comparable, V any] struct {
+	items    []Item[K, V]
+	seq      []uint64 // insertion sequence of items[i], for breaking ties; nil if ordering is not stable
+	cmp      CmpFunc[V]
+	compare3 func(x, y V) int
+}
+
+// Items returns the entries, in their current order.
+// The returned slice shares its backing array with s, so sorting s afterwards reorders it.
+func (s *SortableItems[K, V]) Items() []Item[K, V] {
+	return s.items
+}
+
+// Len returns the number of entries.
+func (s *SortableItems[K, V]) Len() int {
+	return len(s.items)
+}
+
+// Less reports whether the entry at position i has a higher priority than the entry at position j.
+func (s *SortableItems[K, V]) Less(i, j int) bool {
+	vi, vj := s.items[i].Value, s.items[j].Value
+	if s.seq == nil {
+		return s.cmp(vi, vj)
+	}
+	if s.compare3 != nil {
+		if c := s.compare3(vi, vj); c != 0 {
+			return c < 0
+		}
+	} else if s.cmp(vi, vj) {
+		return true
+	} else if s.cmp(vj, vi) {
+		return false
+	}
+	return s.seq[i] < s.seq[j]
+}
+
+// Swap swaps the entries at positions i and j.
+func (s *SortableItems[K, V]) Swap(i, j int) {
+	s.items[i], s.items[j] = s.items[j], s.items[i]
+	if s.seq != nil {
+		s.seq[i], s.seq[j] = s.seq[j], s.seq[i]
+	}
+}
+
+// KeysSeq returns an iterator over the keys of the priority queue, in heap order.
+// The highest priority key is yielded first, but the remaining ones aren't sorted by priority.
+//
+// Unlike SnapshotSeq, it doesn't copy the priority queue: the read lock is held for the whole iteration,
+// and released when the iteration finishes or stops early. Hence the loop body must not call any method
+// of the priority queue, and long iterations block writers; SnapshotSeq suits those better.
+func (pq *KeyedPriorityQueue[K, V]) KeysSeq() iter.Seq[K] {
+	return func(yield func(K) bool) {
+		pq.mu.RLock()
+		defer pq.mu.RUnlock()
+
+		for _, k := range pq.pm {
+			if !pq.isDead(k) && !yield(k) {
+				return
+			}
+		}
+	}
+}
+
+// ValuesSeq returns an iterator over the priority values of the priority queue, in heap order.
+// The highest priority value is yielded first, but the remaining ones aren't sorted by priority.
+//
+// Like KeysSeq, the read lock is held for the whole iteration, and released when the iteration
+// finishes or stops early, so the loop body must not call any method of the priority queue.
+func (pq *KeyedPriorityQueue[K, V]) ValuesSeq() iter.Seq[V] {
+	return func(yield func(V) bool) {
+		pq.mu.RLock()
+		defer pq.mu.RUnlock()
+
+		for i, k := range pq.pm {
+			if !pq.isDead(k) && !yield(pq.pv[i]) {
+				return
+			}
+		}
+	}
+}
+
+// Peek returns the highest priority key and value from the view.
+// It returns false as its last return value if the view is empty; otherwise, true.
+func (v *KeyedPriorityQueueView[K, V]) Peek() (K, V, bool) {
+	if len(v.pm) == 0 {
+		var k K
+		var val V
+		return k, val, false
+	}
+	return v.pm[0], v.vals[v.pm[0]], true
+}
+
+// Contains returns true if the given key k exists in the view; otherwise, false.
+func (v *KeyedPriorityQueueView[K, V]) Contains(k K) bool {
+	_, ok := v.vals[k]
+	return ok
+}
+
+// ValueOf returns the priority value associated with the given key k.
+// It returns false as its last return value if there's no such key k
+// in the view; otherwise, true.
+func (v *KeyedPriorityQueueView[K, V]) ValueOf(k K) (V, bool) {
+	val, ok := v.vals[k]
+	return val, ok
+}
+
+// Len returns the size of the view.
+func (v *KeyedPriorityQueueView[K, V]) Len() int {
+	return len(v.pm)
+}
+
+// ForEach calls fn for each key and value in the view, in heap order.
+// The highest priority entry is visited first, but the remaining ones aren't sorted by priority.
+// If fn returns false, ForEach stops the iteration.
+func (v *KeyedPriorityQueueView[K, V]) ForEach(fn func(k K, v V) bool) {
+	for _, k := range v.pm {
+		if !fn(k, v.vals[k]) {
+			return
+		}
+	}
+}
+
+// ReadOnly is the read-only interface of a keyed priority queue.
+// It's implemented by KeyedPriorityQueueView and by the facade passed to the function given to View.
+type ReadOnly[K comparable, V any] interface {
+	// Peek returns the highest priority key and value.
+	// It returns false as its last return value if there are no entries; otherwise, true.
+	Peek() (K, V, bool)
+	// Contains returns true if the given key k exists; otherwise, false.
+	Contains(k K) bool
+	// ValueOf returns the priority value associated with the given key k.
+	// It returns false as its last return value if there's no such key k; otherwise, true.
+	ValueOf(k K) (V, bool)
+	// Len returns the number of entries.
+	Len() int
+	// ForEach calls fn for each key and value in heap order, stopping if fn returns false.
+	ForEach(fn func(k K, v V) bool)
+}
+
+// View calls fn with a read-only facade of the priority queue, while holding its read lock.
+// It allows several reads, such as Len, Peek and Contains, to be consistent with each other,
+// since no mutation can happen until fn returns.
+//
+// fn must not call any method of the priority queue, nor retain the facade after returning:
+// the facade is only valid during the call to fn.
+func (pq *KeyedPriorityQueue[K, V]) View(fn func(r ReadOnly[K, V])) {
+	pq.mu.RLock()
+	defer pq.mu.RUnlock()
+
+	r := &readOnly[K, V]{pq: pq}
+	defer func() { r.pq = nil }()
+	fn(r)
+}
+
+// readOnly is a ReadOnly facade of a KeyedPriorityQueue whose read lock is held by the caller.
+type readOnly[K comparable, V any] struct {
+	pq *KeyedPriorityQueue[K, V]
+}
+
+func (r *readOnly[K, V]) Peek() (K, V, bool) {
+	if len(r.pq.pm) == 0 {
+		var k K
+		var v V
+		return k, v, false
+	}
+	return r.pq.pm[0], r.pq.value(0), true
+}
+
+func (r *readOnly[K, V]) Contains(k K) bool {
+	_, ok := r.pq.index(k)
+	return ok
+}
+
+func (r *readOnly[K, V]) ValueOf(k K) (V, bool) {
+	i, ok := r.pq.index(k)
+	if !ok {
+		var v V
+		return v, false
+	}
+	return r.pq.value(i), true
+}
+
+func (r *readOnly[K, V]) Len() int {
+	return r.pq.Len()
+}
+
+func (r *readOnly[K, V]) ForEach(fn func(k K, v V) bool) {
+	for i, k := range r.pq.pm {
+		if !r.pq.isDead(k) && !fn(k, r.pq.value(i)) {
+			return
+		}
+	}
+}
@@ -0,0 +1,498 @@
+package kpq
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestKeyedPriorityQueue_Snapshot(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	view := pq.Snapshot()
+
+	// mutations after the snapshot must not be reflected in the view.
+	pq.Pop()
+	pq.Update("last", 1)
+	pq.Push("new", 2)
+
+	if got, want := view.Len(), len(items); got != want {
+		t.Errorf("view.Len(): got %d; want %d", got, want)
+	}
+
+	gotKey, gotVal, ok := view.Peek()
+	if !ok {
+		t.Fatal("view.Peek(): got unexpected empty view")
+	}
+	if gotKey != "first" || gotVal != 6 {
+		t.Errorf("view.Peek(): got (%q, %d); want (%q, %d)", gotKey, gotVal, "first", 6)
+	}
+
+	if view.Contains("new") {
+		t.Errorf("view.Contains(%q): got unexpected key in view", "new")
+	}
+
+	for _, item := range items {
+		got, ok := view.ValueOf(item.key)
+		if !ok {
+			t.Errorf("view.ValueOf(%q): got no key in view", item.key)
+		}
+		if got != item.val {
+			t.Errorf("view.ValueOf(%q): got %d; want %d", item.key, got, item.val)
+		}
+	}
+
+	t.Run("ForEach", func(t *testing.T) {
+		seen := make(map[string]int)
+		view.ForEach(func(k string, v int) bool {
+			seen[k] = v
+			return true
+		})
+
+		if got, want := len(seen), len(items); got != want {
+			t.Errorf("view.ForEach(): got %d entries; want %d", got, want)
+		}
+		for _, item := range items {
+			if got := seen[item.key]; got != item.val {
+				t.Errorf("view.ForEach(): got value %d for key %q; want %d", got, item.key, item.val)
+			}
+		}
+	})
+
+	t.Run("ForEach_Stop", func(t *testing.T) {
+		var calls int
+		view.ForEach(func(k string, v int) bool {
+			calls++
+			return false
+		})
+
+		if calls != 1 {
+			t.Errorf("view.ForEach(): got %d calls; want 1", calls)
+		}
+	})
+}
+
+func TestKeyedPriorityQueueView_Peek_EmptyView(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool { return x < y })
+
+	_, _, ok := pq.Snapshot().Peek()
+	if ok {
+		t.Error("view.Peek(): got unexpected non empty view")
+	}
+}
+
+func TestKeyedPriorityQueue_SnapshotSeq(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	seq := pq.SnapshotSeq()
+
+	seen := make(map[string]int)
+	for k, v := range seq {
+		if len(seen) == 0 && k != "first" {
+			t.Errorf("pq.SnapshotSeq(): got first key %q; want %q", k, "first")
+		}
+		seen[k] = v
+
+		// mutations while iterating must neither block nor be reflected in the iteration.
+		pq.Remove(k)
+		pq.Push(k+"_new", v)
+	}
+
+	if got, want := len(seen), len(items); got != want {
+		t.Errorf("pq.SnapshotSeq(): got %d entries; want %d", got, want)
+	}
+	for _, item := range items {
+		if got := seen[item.key]; got != item.val {
+			t.Errorf("pq.SnapshotSeq(): got value %d for key %q; want %d", got, item.key, item.val)
+		}
+	}
+
+	var calls int
+	for range seq {
+		calls++
+		break
+	}
+	if calls != 1 {
+		t.Errorf("pq.SnapshotSeq(): got %d calls after break; want 1", calls)
+	}
+}
+
+func TestKeyedPriorityQueue_SmallestSeq(t *testing.T) {
+	pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+		return x < y
+	}, WithLazyDeletion[int, int](1))
+
+	r := rand.New(rand.NewSource(1))
+	for k := 0; k < 50; k++ {
+		pq.Push(k, r.Intn(100))
+	}
+	for k := 0; k < 50; k += 5 {
+		pq.Remove(k)
+	}
+	want := pq.Items()
+
+	for _, n := range []int{-1, 0, 1, 10, 45, 100} {
+		seq := pq.SmallestSeq(n)
+
+		var got []Item[int, int]
+		for k, v := range seq {
+			got = append(got, Item[int, int]{Key: k, Value: v})
+		}
+		if wantLen := max(0, min(n, len(want))); len(got) != wantLen {
+			t.Fatalf("pq.SmallestSeq(%d): got %d entries; want %d", n, len(got), wantLen)
+		}
+		for i := range got {
+			if got[i].Value != want[i].Value {
+				t.Errorf("pq.SmallestSeq(%d): got value %d at rank %d; want %d", n, got[i].Value, i, want[i].Value)
+			}
+		}
+
+		var calls int
+		for range seq {
+			calls++
+			break
+		}
+		if n > 0 && calls != 1 {
+			t.Errorf("pq.SmallestSeq(%d): got %d yields after break; want 1", n, calls)
+		}
+	}
+
+	if got, want := pq.Len(), 40; got != want {
+		t.Errorf("pq.Len(): got %d after iterating; want %d", got, want)
+	}
+}
+
+func TestKeyedPriorityQueue_SortableSnapshot(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	})
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+
+	s := pq.SortableSnapshot()
+	sort.Sort(s)
+
+	want := []Item[string, int]{
+		{Key: "first", Value: 6},
+		{Key: "second", Value: 8},
+		{Key: "third", Value: 9},
+		{Key: "fourth", Value: 10},
+		{Key: "last", Value: 20},
+	}
+	got := s.(*SortableItems[string, int]).Items()
+	if len(got) != len(want) {
+		t.Fatalf("pq.SortableSnapshot(): got %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("pq.SortableSnapshot()[%d]: got %v; want %v", i, got[i], want[i])
+		}
+	}
+
+	// sorting the snapshot must not affect the priority queue.
+	sort.Sort(sort.Reverse(s))
+	if err := pq.CheckInvariant(); err != nil {
+		t.Errorf("pq.CheckInvariant(): got %v; want nil", err)
+	}
+	if k, _, _ := pq.Peek(); k != "first" {
+		t.Errorf("pq.Peek(): got key %q; want %q", k, "first")
+	}
+}
+
+func TestKeyedPriorityQueue_SortableSnapshot_StableOrdering(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithStableOrdering[string, int]())
+
+	keys := []string{"e", "d", "c", "b", "a"}
+	for _, k := range keys {
+		pq.MustPush(k, 1)
+	}
+
+	s := pq.SortableSnapshot()
+	sort.Sort(s)
+
+	for i, item := range s.(*SortableItems[string, int]).Items() {
+		if item.Key != keys[i] {
+			t.Errorf("pq.SortableSnapshot()[%d]: got key %q; want %q", i, item.Key, keys[i])
+		}
+	}
+}
+
+var _ ReadOnly[string, int] = (*KeyedPriorityQueueView[string, int])(nil)
+
+func TestKeyedPriorityQueue_View(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithLazyDeletion[string, int](1))
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+	pq.Remove("third")
+
+	var calls int
+	pq.View(func(r ReadOnly[string, int]) {
+		calls++
+
+		if got, want := r.Len(), len(items)-1; got != want {
+			t.Errorf("r.Len(): got %d; want %d", got, want)
+		}
+
+		if k, v, ok := r.Peek(); !ok || k != "first" || v != 6 {
+			t.Errorf("r.Peek(): got (%q, %d, %t); want (%q, %d, %t)", k, v, ok, "first", 6, true)
+		}
+
+		if !r.Contains("last") {
+			t.Errorf("r.Contains(%q): got false; want true", "last")
+		}
+		if r.Contains("third") {
+			t.Errorf("r.Contains(%q): got true; want false", "third")
+		}
+
+		if v, ok := r.ValueOf("second"); !ok || v != 8 {
+			t.Errorf("r.ValueOf(%q): got (%d, %t); want (%d, %t)", "second", v, ok, 8, true)
+		}
+		if _, ok := r.ValueOf("third"); ok {
+			t.Errorf("r.ValueOf(%q): got true; want false", "third")
+		}
+
+		seen := make(map[string]int)
+		r.ForEach(func(k string, v int) bool {
+			seen[k] = v
+			return true
+		})
+		if _, ok := seen["third"]; ok || len(seen) != len(items)-1 {
+			t.Errorf("r.ForEach(): got entries %v; want all but %q", seen, "third")
+		}
+	})
+
+	if calls != 1 {
+		t.Errorf("pq.View(): got %d calls to fn; want 1", calls)
+	}
+}
+
+func TestKeyedPriorityQueue_View_EmptyQueue(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool { return x < y })
+
+	pq.View(func(r ReadOnly[string, int]) {
+		if _, _, ok := r.Peek(); ok {
+			t.Error("r.Peek(): got unexpected non empty priority queue")
+		}
+	})
+}
+
+func TestKeyedPriorityQueue_KeysSeq(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithLazyDeletion[string, int](1))
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+	pq.Remove("third")
+
+	seen := make(map[string]bool)
+	for k := range pq.KeysSeq() {
+		if len(seen) == 0 && k != "first" {
+			t.Errorf("pq.KeysSeq(): got first key %q; want %q", k, "first")
+		}
+		seen[k] = true
+	}
+
+	if got, want := len(seen), len(items)-1; got != want {
+		t.Errorf("pq.KeysSeq(): got %d keys; want %d", got, want)
+	}
+	if seen["third"] {
+		t.Errorf("pq.KeysSeq(): got removed key %q", "third")
+	}
+
+	for range pq.KeysSeq() {
+		break
+	}
+
+	// the lock must be released after stopping early.
+	if err := pq.Push("new", 1); err != nil {
+		t.Errorf("pq.Push(%q, 1): got unexpected error %v", "new", err)
+	}
+}
+
+func TestKeyedPriorityQueue_ValuesSeq(t *testing.T) {
+	pq := NewKeyedPriorityQueue[string](func(x, y int) bool {
+		return x < y
+	}, WithLazyDeletion[string, int](1))
+
+	items := []struct {
+		key string
+		val int
+	}{
+		{key: "fourth", val: 10},
+		{key: "second", val: 8},
+		{key: "third", val: 9},
+		{key: "first", val: 6},
+		{key: "last", val: 20},
+	}
+
+	for _, item := range items {
+		err := pq.Push(item.key, item.val)
+		if err != nil {
+			t.Fatalf("Push(%v, %v): got unexpected error %v", item.key, item.val, err)
+		}
+	}
+	pq.Remove("third")
+
+	var got []int
+	for v := range pq.ValuesSeq() {
+		got = append(got, v)
+	}
+
+	if len(got) == 0 || got[0] != 6 {
+		t.Fatalf("pq.ValuesSeq(): got %v; want 6 first", got)
+	}
+	sort.Ints(got)
+	want := []int{6, 8, 10, 20}
+	if len(got) != len(want) {
+		t.Fatalf("pq.ValuesSeq(): got %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("pq.ValuesSeq(): got values %v; want %v", got, want)
+			break
+		}
+	}
+
+	for range pq.ValuesSeq() {
+		break
+	}
+
+	// the lock must be released after stopping early.
+	if err := pq.Push("new", 1); err != nil {
+		t.Errorf("pq.Push(%q, 1): got unexpected error %v", "new", err)
+	}
+}
+
+func TestKeyedPriorityQueue_DeterministicIteration(t *testing.T) {
+	build := func() *KeyedPriorityQueue[int, int] {
+		pq := NewKeyedPriorityQueue[int](func(x, y int) bool {
+			return x < y
+		}, WithLazyDeletion[int, int](1))
+
+		r := rand.New(rand.NewSource(1))
+		for k := 0; k < 100; k++ {
+			pq.Push(k, r.Intn(10))
+		}
+		for k := 0; k < 100; k += 3 {
+			pq.Remove(k)
+		}
+		return pq
+	}
+
+	keys := func(pq *KeyedPriorityQueue[int, int]) []int {
+		var got []int
+		for k := range pq.KeysSeq() {
+			got = append(got, k)
+		}
+		pq.Snapshot().ForEach(func(k, v int) bool {
+			got = append(got, k)
+			return true
+		})
+		return pq.AppendKeys(got)
+	}
+
+	want := keys(build())
+	for i := 0; i < 10; i++ {
+		got := keys(build())
+		if len(got) != len(want) {
+			t.Fatalf("iteration %d: got %d keys; want %d", i, len(got), len(want))
+		}
+		for j := range want {
+			if got[j] != want[j] {
+				t.Fatalf("iteration %d: got key %d at position %d; want %d", i, got[j], j, want[j])
+			}
+		}
+	}
+}